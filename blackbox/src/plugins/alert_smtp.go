@@ -1,12 +1,14 @@
 package main
 
 import (
+	"os"
 	"fmt"
 	"strings"
 	"strconv"
 	"bytes"
         "gopkg.in/yaml.v2"
 	"io/ioutil"
+	"path/filepath"
 	"net/smtp"
 	"net"
 	"time"
@@ -71,7 +73,12 @@ func SendSMTPMessage(mailserver string, from string, to []string, subject string
 func Handle(check string, failed bool) (string, error) {
 
 	var chk Check
-	b, err := ioutil.ReadFile("/etc/heimdall/plugins.d/alert_smtp.yml")
+	configdir := os.Getenv("HEIMDALL_PLUGIN_CONFIG_DIR")
+	if configdir == "" {
+		configdir = "/etc/heimdall/plugins.d/"
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(configdir, "alert_smtp.yml"))
 	if err != nil {
 		return "", err
 	}
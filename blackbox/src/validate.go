@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"validation"
+	"gopkg.in/yaml.v2"
+)
+
+// ValidateConfig checks a single scraper config file, loading the plugins
+// from its PluginPath.  Plugin names are checked by the caller once every
+// file's plugins are loaded, since they're shared.
+func ValidateConfig(file string, data []byte) (Config, []validation.Error) {
+	var errs []validation.Error
+	fail := func(line int, msg string) {
+		errs = append(errs, validation.Error{File: file, Line: line, Message: msg})
+	}
+
+	c := Config{}
+	err := yaml.UnmarshalStrict(data, &c)
+	if err != nil {
+		return c, validation.YAMLErrors(file, err)
+	}
+
+	if c.PluginPath == "" {
+		fail(0, "missing PluginPath")
+	} else if _, err := os.Stat(c.PluginPath); err != nil {
+		fail(validation.LineOf(data, "PluginPath", ""), "PluginPath: " + err.Error())
+	} else if err := LoadPlugins(c.PluginPath); err != nil {
+		fail(validation.LineOf(data, "PluginPath", ""), "loading plugins from " + c.PluginPath + ": " + err.Error())
+	}
+
+	if c.DefaultScrapeTime < 0 {
+		fail(validation.LineOf(data, "DefaultScrapeTime", ""), "DefaultScrapeTime can't be negative")
+	}
+
+	if len(c.Hosts) == 0 {
+		fail(0, "no Hosts")
+	}
+
+	for _, h := range c.Hosts {
+		line := validation.LineOf(data, "HostName", h.HostName)
+		name := strconv.Quote(h.HostName)
+
+		if h.HostName == "" {
+			fail(line, "host without a HostName")
+		}
+
+		if h.ScrapeTime < 0 {
+			fail(line, "host " + name + ": ScrapeTime can't be negative")
+		}
+
+		for _, p := range h.HostPaths {
+			if !strings.HasPrefix(p, "/") {
+				fail(validation.LineOf(data, "-", p), "host " + name + ": HostPath " + strconv.Quote(p) + " must start with /")
+			}
+		}
+
+		if h.PageSize < 0 {
+			fail(line, "host " + name + ": PageSize can't be negative")
+		}
+
+		if (h.HMACKey == "") != (h.HMACSecret == "") {
+			fail(line, "host " + name + ": HMACKey and HMACSecret go together")
+		}
+
+		if h.TLS.Enabled {
+			_, err := h.TLS.Client()
+			if err != nil {
+				fail(line, "host " + name + ": bad TLS settings: " + err.Error())
+			}
+		}
+	}
+
+	return c, errs
+}
+
+// checkPluginNames reports names in a config that aren't a loaded plugin.
+func checkPluginNames(file string, data []byte, c Config) []validation.Error {
+	var loaded []string
+	for _, p := range plugins {
+		loaded = append(loaded, p.Name)
+	}
+
+	var errs []validation.Error
+	check := func(list []string) {
+		errs = append(errs, validation.Unknown(file, data, "plugin", loaded, list)...)
+	}
+
+	check(c.DefaultPlugins)
+	check(c.DefaultFailPlugins)
+	for _, h := range c.Hosts {
+		check(h.Plugins)
+		check(h.FailurePlugins)
+	}
+
+	return errs
+}
+
+// Validate checks every file in the config dir, printing any problems as
+// file:line: message.  It returns the exit status for the validate
+// subcommand.
+func Validate() int {
+	var errs []validation.Error
+
+	files, err := ioutil.ReadDir(configDir)
+	if err != nil {
+		fmt.Println("Error Reading " + configDir + ": " + err.Error())
+		return 1
+	}
+
+	type parsed struct {
+		path string
+		data []byte
+		c    Config
+	}
+	var all []parsed
+	hosts := make(map[string][]string)
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(configDir, f.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs = append(errs, validation.Error{File: path, Message: err.Error()})
+			continue
+		}
+
+		c, cerrs := ValidateConfig(path, b)
+		errs = append(errs, cerrs...)
+		if len(cerrs) > 0 && c.Hosts == nil {
+			continue
+		}
+
+		all = append(all, parsed{path, b, c})
+		for _, h := range c.Hosts {
+			if h.HostName != "" {
+				hosts[h.HostName] = append(hosts[h.HostName], path)
+			}
+		}
+	}
+
+	for _, p := range all {
+		errs = append(errs, checkPluginNames(p.path, p.data, p.c)...)
+	}
+
+	for host, paths := range hosts {
+		if len(paths) > 1 {
+			errs = append(errs, validation.Error{File: paths[0], Message: "host " + strconv.Quote(host) + " is listed " + strconv.Itoa(len(paths)) + " times (" + strings.Join(paths, ", ") + ")"})
+		}
+	}
+
+	return validation.Report(errs, len(all))
+}
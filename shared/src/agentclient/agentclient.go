@@ -0,0 +1,123 @@
+// Package agentclient is how the scraper and blackbox read results from an
+// agent: paging through /checks as a named consumer and acknowledging what
+// they've processed, or draining /checkandclear.
+package agentclient
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"signing"
+	"strconv"
+	"strings"
+)
+
+// The header an agent puts its continuation token in when there are more
+// results than one page holds.
+const NextPageHeader = "X-Heimdall-Next"
+
+// Credentials are what to call an agent with, see signing.Authorize.
+type Credentials struct {
+	Token      string
+	HMACKey    string
+	HMACSecret string
+}
+
+func WithQuery(u string, key string, value string) string {
+	sep := "?"
+	if strings.Contains(u, "?") {
+		sep = "&"
+	}
+
+	return u + sep + url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
+
+// Ack tells an agent we're done with everything up to the seq in ackurl, so
+// it can prune them once its other consumers are done too.
+func Ack(client *http.Client, ackurl string, creds Credentials) error {
+	req, err := http.NewRequest("POST", ackurl, nil)
+	if err != nil {
+		return err
+	}
+
+	signing.Authorize(req, creds.Token, creds.HMACKey, creds.HMACSecret, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("agent returned " + resp.Status)
+	}
+
+	return nil
+}
+
+// ScrapePage fetches one page of results.  It returns the body and the
+// agent's continuation token, which is empty on the last page.  The
+// transport asks for gzip and unpacks it for us.
+func ScrapePage(client *http.Client, pageurl string, creds Credentials) ([]byte, string, error) {
+	req, err := http.NewRequest("GET", pageurl, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	signing.Authorize(req, creds.Token, creds.HMACKey, creds.HMACSecret, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", errors.New("agent returned " + resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return body, resp.Header.Get(NextPageHeader), nil
+}
+
+// NextPage is the URL of the page after one fetched from scrapeurl.
+// /checkandclear removes what it returned, so the next page is scrapeurl
+// again, /checks needs the continuation token.
+func NextPage(scrapeurl string, path string, next string) string {
+	if next == "" {
+		return ""
+	}
+
+	if strings.HasSuffix(path, "/checkandclear") {
+		return scrapeurl
+	}
+
+	return WithQuery(scrapeurl, "continue", next)
+}
+
+// AckURL is where to acknowledge a page of results as consumer: the highest
+// Seq in body.  It's empty if body holds no results.
+func AckURL(base string, consumer string, body []byte) string {
+	var chks []struct {
+		Seq uint64 `json:"Seq"`
+	}
+	json.Unmarshal(body, &chks)
+
+	var maxseq uint64
+	for _, chk := range chks {
+		if chk.Seq > maxseq {
+			maxseq = chk.Seq
+		}
+	}
+
+	if maxseq == 0 {
+		return ""
+	}
+
+	ackurl := WithQuery(base + "/ack", "consumer", consumer)
+	return WithQuery(ackurl, "seq", strconv.FormatUint(maxseq, 10))
+}
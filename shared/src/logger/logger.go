@@ -0,0 +1,309 @@
+// Package logger is the leveled logging used by every Heimdall binary.  It
+// lives outside the binaries' own trees, so build them with this directory
+// on GOPATH as well, e.g. GOPATH=$PWD/whitebox/agent:$PWD/shared.
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/syslog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return "unknown"
+	}
+
+	return levelNames[l]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return Level(i), nil
+		}
+	}
+
+	if strings.EqualFold(name, "warning") {
+		return LevelWarn, nil
+	}
+
+	return LevelInfo, errors.New("unknown log level: " + name)
+}
+
+// Fields are extra key/values logged with a message.  The ones used across
+// Heimdall are label (a check's Label), plugin (a scraper plugin's name) and
+// agent (the agent a scraper is talking to).
+type Fields map[string]string
+
+// Options configure the logger.  Target is "stdout", "stderr", "syslog" or a
+// file path.
+// Format is "text" (the original Heimdall format), "json" or "logfmt".  A
+// file target is rotated when it would grow past MaxSize bytes, keeping
+// MaxBackups old files as path.1, path.2 and so on.  MaxSize 0 means never
+// rotate.
+type Options struct {
+	Component  string
+	Level      string
+	Format     string
+	Target     string
+	MaxSize    int64
+	MaxBackups int
+}
+
+type logger struct {
+	sync.Mutex
+
+	component string
+	host      string
+	level     Level
+	format    string
+	target    string
+
+	maxsize    int64
+	maxbackups int
+
+	file   *os.File
+	size   int64
+	syslog *syslog.Writer
+}
+
+var std = &logger{component: "heimdall", level: LevelInfo, format: "text", target: "stdout"}
+
+// Init replaces the logger's settings.  Until it's called everything goes to
+// stdout in the text format.
+func Init(opts Options) error {
+	level := LevelInfo
+	if opts.Level != "" {
+		l, err := ParseLevel(opts.Level)
+		if err != nil {
+			return err
+		}
+		level = l
+	}
+
+	format := strings.ToLower(opts.Format)
+	if format == "" {
+		format = "text"
+	}
+	if format != "text" && format != "json" && format != "logfmt" {
+		return errors.New("unknown log format: " + opts.Format)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	l := &logger{
+		component:  opts.Component,
+		host:       host,
+		level:      level,
+		format:     format,
+		target:     opts.Target,
+		maxsize:    opts.MaxSize,
+		maxbackups: opts.MaxBackups,
+	}
+
+	switch opts.Target {
+	case "", "stdout", "-":
+		l.target = "stdout"
+	case "stderr":
+	case "syslog":
+		w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "heimdall-"+opts.Component)
+		if err != nil {
+			return err
+		}
+		l.syslog = w
+	default:
+		err := l.openFile()
+		if err != nil {
+			return err
+		}
+	}
+
+	std.Lock()
+	defer std.Unlock()
+
+	if std.file != nil {
+		std.file.Close()
+	}
+	if std.syslog != nil {
+		std.syslog.Close()
+	}
+
+	std.component = l.component
+	std.host = l.host
+	std.level = l.level
+	std.format = l.format
+	std.target = l.target
+	std.maxsize = l.maxsize
+	std.maxbackups = l.maxbackups
+	std.file = l.file
+	std.size = l.size
+	std.syslog = l.syslog
+
+	return nil
+}
+
+func (l *logger) openFile() error {
+	file, err := os.OpenFile(l.target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, moves the current
+// file to path.1 and starts a new one.
+func (l *logger) rotate() error {
+	l.file.Close()
+	l.file = nil
+
+	if l.maxbackups > 0 {
+		os.Remove(l.target + "." + strconv.Itoa(l.maxbackups))
+		for i := l.maxbackups - 1; i >= 1; i-- {
+			os.Rename(l.target+"."+strconv.Itoa(i), l.target+"."+strconv.Itoa(i+1))
+		}
+		os.Rename(l.target, l.target+".1")
+	} else {
+		os.Remove(l.target)
+	}
+
+	return l.openFile()
+}
+
+func quoteLogfmt(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\n") {
+		return strconv.Quote(value)
+	}
+
+	return value
+}
+
+func (l *logger) formatLine(now time.Time, level Level, msg string, fields Fields) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	switch l.format {
+	case "json":
+		entry := map[string]string{
+			"time":      now.Format(time.RFC3339),
+			"level":     level.String(),
+			"component": l.component,
+			"host":      l.host,
+			"msg":       msg,
+		}
+		for _, k := range keys {
+			entry[k] = fields[k]
+		}
+
+		jsn, _ := json.Marshal(entry)
+		return string(jsn)
+
+	case "logfmt":
+		line := "time=" + now.Format(time.RFC3339) + " level=" + level.String() + " component=" + quoteLogfmt(l.component) + " host=" + quoteLogfmt(l.host) + " msg=" + quoteLogfmt(msg)
+		for _, k := range keys {
+			line += " " + k + "=" + quoteLogfmt(fields[k])
+		}
+		return line
+
+	default:
+		line := now.Local().Format("Jan 02 2006 03:04:05") + " - Heimdall " + l.component + " [" + strings.ToUpper(level.String()) + "]: " + msg
+		for _, k := range keys {
+			line += " " + k + "=" + quoteLogfmt(fields[k])
+		}
+		return line
+	}
+}
+
+func (l *logger) log(level Level, msg string, fields []Fields) {
+	l.Lock()
+	defer l.Unlock()
+
+	if level < l.level {
+		return
+	}
+
+	merged := Fields{}
+	for _, f := range fields {
+		for k, v := range f {
+			merged[k] = v
+		}
+	}
+
+	line := l.formatLine(time.Now(), level, msg, merged)
+
+	if l.syslog != nil {
+		switch level {
+		case LevelDebug:
+			l.syslog.Debug(line)
+		case LevelInfo:
+			l.syslog.Info(line)
+		case LevelWarn:
+			l.syslog.Warning(line)
+		default:
+			l.syslog.Err(line)
+		}
+		return
+	}
+
+	if l.file == nil {
+		if l.target == "stderr" {
+			fmt.Fprintln(os.Stderr, line)
+		} else {
+			fmt.Println(line)
+		}
+		return
+	}
+
+	if l.maxsize > 0 && l.size+int64(len(line)+1) > l.maxsize {
+		err := l.rotate()
+		if err != nil {
+			fmt.Println("Failed To Rotate Log File: " + err.Error())
+			fmt.Println(line)
+			return
+		}
+	}
+
+	n, err := l.file.WriteString(line + "\n")
+	l.size += int64(n)
+	if err != nil {
+		fmt.Println("Failed To Write Log File: " + err.Error())
+	}
+}
+
+func Debug(msg string, fields ...Fields) { std.log(LevelDebug, msg, fields) }
+func Info(msg string, fields ...Fields)  { std.log(LevelInfo, msg, fields) }
+func Warn(msg string, fields ...Fields)  { std.log(LevelWarn, msg, fields) }
+func Error(msg string, fields ...Fields) { std.log(LevelError, msg, fields) }
@@ -0,0 +1,127 @@
+// Package service holds the command line settings and start up every
+// Heimdall binary has in common: listening, logging, the pid file and TLS.
+// Each binary adds its own flags, e.g. where its configs live, before
+// calling flag.Parse.
+package service
+
+import (
+	"flag"
+	"io/ioutil"
+	"logger"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"tlsutil"
+)
+
+// Options are the settings from the shared flags.
+type Options struct {
+	Listen        string
+	LogFile       string
+	LogLevel      string
+	LogFormat     string
+	LogMaxSize    int
+	LogMaxBackups int
+	PidFile       string
+	TLSCert       string
+	TLSKey        string
+	TLSClientCA   string
+}
+
+// Flags registers the shared flags, with listen and logfile as the defaults
+// for -listen and -log.  Every flag can also be set from the environment,
+// the flag wins if both are given.
+func (o *Options) Flags(listen string, logfile string) {
+	flag.StringVar(&o.Listen, "listen", EnvOr("HEIMDALL_LISTEN", listen), "address to serve the API on (HEIMDALL_LISTEN)")
+	flag.StringVar(&o.LogFile, "log", EnvOr("HEIMDALL_LOG", logfile), "log file, \"stdout\", \"stderr\" or \"syslog\" (HEIMDALL_LOG)")
+	flag.StringVar(&o.LogLevel, "log-level", EnvOr("HEIMDALL_LOG_LEVEL", "info"), "debug, info, warn or error (HEIMDALL_LOG_LEVEL)")
+	flag.StringVar(&o.LogFormat, "log-format", EnvOr("HEIMDALL_LOG_FORMAT", "text"), "text, json or logfmt (HEIMDALL_LOG_FORMAT)")
+	flag.IntVar(&o.LogMaxSize, "log-max-size", EnvIntOr("HEIMDALL_LOG_MAX_SIZE", 100), "rotate the log file after this many MB, 0 to never rotate (HEIMDALL_LOG_MAX_SIZE)")
+	flag.IntVar(&o.LogMaxBackups, "log-max-backups", EnvIntOr("HEIMDALL_LOG_MAX_BACKUPS", 5), "rotated log files to keep (HEIMDALL_LOG_MAX_BACKUPS)")
+	flag.StringVar(&o.PidFile, "pidfile", EnvOr("HEIMDALL_PIDFILE", ""), "write the process id to this file (HEIMDALL_PIDFILE)")
+	flag.StringVar(&o.TLSCert, "tls-cert", EnvOr("HEIMDALL_TLS_CERT", ""), "serve over TLS with this certificate (HEIMDALL_TLS_CERT)")
+	flag.StringVar(&o.TLSKey, "tls-key", EnvOr("HEIMDALL_TLS_KEY", ""), "private key for -tls-cert (HEIMDALL_TLS_KEY)")
+	flag.StringVar(&o.TLSClientCA, "tls-client-ca", EnvOr("HEIMDALL_TLS_CLIENT_CA", ""), "require client certificates signed by this CA (HEIMDALL_TLS_CLIENT_CA)")
+}
+
+func EnvOr(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return def
+}
+
+func EnvIntOr(name string, def int) int {
+	if value, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return value
+	}
+
+	return def
+}
+
+// InitLogging sets up the logger package.  If the log target can't be
+// opened it carries on logging to stderr rather than not starting, e.g. when
+// the default relative log path isn't writable from the working directory,
+// and returns the error so it can be reported.
+func (o *Options) InitLogging(component string) error {
+	err := logger.Init(o.loggerOptions(component))
+	if err != nil {
+		o.LogFile = "stderr"
+		logger.Init(o.loggerOptions(component))
+	}
+
+	return err
+}
+
+func (o *Options) loggerOptions(component string) logger.Options {
+	return logger.Options{
+		Component:  component,
+		Level:      o.LogLevel,
+		Format:     o.LogFormat,
+		Target:     o.LogFile,
+		MaxSize:    int64(o.LogMaxSize) * 1024 * 1024,
+		MaxBackups: o.LogMaxBackups,
+	}
+}
+
+// WritePidFile writes the pid file, if one was asked for, and removes it
+// again on SIGINT or SIGTERM.
+func (o *Options) WritePidFile() error {
+	if o.PidFile == "" {
+		return nil
+	}
+
+	err := ioutil.WriteFile(o.PidFile, []byte(strconv.Itoa(os.Getpid()) + "\n"), 0644)
+	if err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		os.Remove(o.PidFile)
+		os.Exit(0)
+	}()
+
+	return nil
+}
+
+// Serve runs the API on Listen, over TLS if a certificate was given.  With a
+// client CA, clients must present a certificate signed by it.
+func (o *Options) Serve(handler http.Handler) error {
+	if o.TLSCert == "" && o.TLSKey == "" {
+		return http.ListenAndServe(o.Listen, handler)
+	}
+
+	tlsconfig, err := tlsutil.ServerConfig(o.TLSClientCA)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Addr: o.Listen, Handler: handler, TLSConfig: tlsconfig}
+	return server.ListenAndServeTLS(o.TLSCert, o.TLSKey)
+}
@@ -0,0 +1,183 @@
+// Package signing is the HMAC-SHA256 request signing Heimdall components
+// use to call each other: the scraper, blackbox and heimdall-submit sign
+// requests to the agent with Authorize, the agent checks them with a
+// Verifier.  Both ends have to agree byte for byte, so this is the only
+// implementation.
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderKey       = "X-Heimdall-Key"
+	HeaderTimestamp = "X-Heimdall-Timestamp"
+	HeaderNonce     = "X-Heimdall-Nonce"
+	HeaderSignature = "X-Heimdall-Signature"
+)
+
+// How far the timestamp on a signed request may be from our clock.
+const DefaultMaxSkew = 300 * time.Second
+
+// The largest signed body a Verifier reads by default.
+const DefaultMaxBody = 1024 * 1024
+
+var (
+	ErrUnsigned     = errors.New("request isn't signed")
+	ErrBadTimestamp = errors.New("missing or out of date timestamp")
+	ErrBadSignature = errors.New("bad signature")
+	ErrReplayed     = errors.New("request already seen")
+	ErrTooLarge     = errors.New("request body too large")
+)
+
+// SignatureFor is what a client puts in X-Heimdall-Signature: the hex
+// HMAC-SHA256 of the method, request URI, X-Heimdall-Timestamp,
+// X-Heimdall-Nonce and the hex SHA256 of the body, separated by newlines.
+func SignatureFor(secret string, method string, uri string, timestamp string, nonce string, body []byte) string {
+	bodysum := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n" + hex.EncodeToString(bodysum[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authorize adds credentials to req: a bearer token, or a signature made
+// with secret under the name keyname.  body must be what req will send.
+// With neither, req is left alone.
+func Authorize(req *http.Request, token string, keyname string, secret string, body []byte) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer " + token)
+		return
+	}
+
+	if keyname == "" || secret == "" {
+		return
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := newNonce()
+
+	req.Header.Set(HeaderKey, keyname)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, SignatureFor(secret, req.Method, req.URL.RequestURI(), timestamp, nonce, body))
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Verifier checks signed requests.  A nonce is remembered for as long as its
+// timestamp is acceptable, so a captured request can't be sent again.  The
+// zero value uses DefaultMaxSkew and DefaultMaxBody.
+type Verifier struct {
+	MaxSkew time.Duration
+	MaxBody int64
+
+	lock      sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// Signed says whether r carries a signature at all, as opposed to some
+// other kind of credential.
+func Signed(r *http.Request) bool {
+	return r.Header.Get(HeaderKey) != ""
+}
+
+// Verify checks the signature on r, using the secret secretFor returns for
+// the key r was signed with, and returns that key's name.  The body is read
+// to check it, then put back for the handler.
+func (v *Verifier) Verify(r *http.Request, secretFor func(keyname string) (string, bool)) (string, error) {
+	keyname := r.Header.Get(HeaderKey)
+	if keyname == "" {
+		return "", ErrUnsigned
+	}
+
+	maxskew := v.MaxSkew
+	if maxskew <= 0 {
+		maxskew = DefaultMaxSkew
+	}
+
+	timestamp := r.Header.Get(HeaderTimestamp)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrBadTimestamp
+	}
+
+	signed := time.Unix(ts, 0)
+	now := time.Now()
+	if now.Sub(signed) > maxskew || signed.Sub(now) > maxskew {
+		return "", ErrBadTimestamp
+	}
+
+	maxbody := v.MaxBody
+	if maxbody <= 0 {
+		maxbody = DefaultMaxBody
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxbody + 1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(body)) > maxbody {
+		return "", ErrTooLarge
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	secret, ok := secretFor(keyname)
+	if !ok {
+		return "", ErrBadSignature
+	}
+
+	nonce := r.Header.Get(HeaderNonce)
+	expected := SignatureFor(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if nonce == "" || !hmac.Equal([]byte(expected), []byte(r.Header.Get(HeaderSignature))) {
+		return "", ErrBadSignature
+	}
+
+	if !v.remember(keyname + "\n" + nonce, signed.Add(maxskew), now) {
+		return "", ErrReplayed
+	}
+
+	return keyname, nil
+}
+
+// remember records a nonce until expires, and says whether it was new.
+func (v *Verifier) remember(nonce string, expires time.Time, now time.Time) bool {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.seen == nil {
+		v.seen = make(map[string]time.Time)
+	}
+
+	if now.Sub(v.lastPrune) > time.Minute {
+		for n, exp := range v.seen {
+			if now.After(exp) {
+				delete(v.seen, n)
+			}
+		}
+		v.lastPrune = now
+	}
+
+	if exp, ok := v.seen[nonce]; ok && !now.After(exp) {
+		return false
+	}
+
+	v.seen[nonce] = expires
+	return true
+}
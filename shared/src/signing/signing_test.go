@@ -0,0 +1,49 @@
+package signing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSignatureFor(t *testing.T) {
+	// Worked out independently with Python's hmac and hashlib
+	want := "21a6a48f44ac7008de50f822068afab17369b299e5d32e45f0acdd95e237a9f9"
+
+	got := SignatureFor("sekrit", "POST", "/submit?x=1", "1700000000", "0123456789abcdef", []byte(`{"Label":"x"}`))
+	if got != want {
+		t.Errorf("SignatureFor = %s, want %s", got, want)
+	}
+}
+
+func TestAuthorizeVerify(t *testing.T) {
+	body := `{"Label":"x"}`
+	secrets := func(keyname string) (string, bool) {
+		return "sekrit", keyname == "signer"
+	}
+
+	req := httptest.NewRequest("POST", "/submit?x=1", strings.NewReader(body))
+	Authorize(req, "", "signer", "sekrit", []byte(body))
+
+	v := &Verifier{}
+	keyname, err := v.Verify(req, secrets)
+	if err != nil || keyname != "signer" {
+		t.Fatalf("Verify = %q, %v, want signer", keyname, err)
+	}
+
+	// The same request again is a replay
+	req.Body = http.NoBody
+	req2 := httptest.NewRequest("POST", "/submit?x=1", strings.NewReader(body))
+	req2.Header = req.Header.Clone()
+	if _, err := v.Verify(req2, secrets); err != ErrReplayed {
+		t.Errorf("replayed request: err = %v, want %v", err, ErrReplayed)
+	}
+
+	// A token is sent as is
+	req = httptest.NewRequest("GET", "/checks", nil)
+	Authorize(req, "tok", "signer", "sekrit", nil)
+	if req.Header.Get("Authorization") != "Bearer tok" || Signed(req) {
+		t.Errorf("with a token: headers %v, want only a bearer token", req.Header)
+	}
+}
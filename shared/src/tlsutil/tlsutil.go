@@ -0,0 +1,112 @@
+// Package tlsutil builds the TLS settings the Heimdall binaries use to talk
+// to each other, so the agent, scraper and blackbox agree on them.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// How long a scrape of, or push to, another component may take.
+const ClientTimeout = 60 * time.Second
+
+func LoadCertPool(cafile string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(cafile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + cafile)
+	}
+
+	return pool, nil
+}
+
+// ClientConfig builds the TLS settings for talking to another Heimdall
+// component.  CAFile verifies the server, CertFile/KeyFile are presented as
+// our client certificate when the server asks for one.
+func ClientConfig(cafile string, certfile string, keyfile string, servername string, insecure bool) (*tls.Config, error) {
+	tlsconfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         servername,
+		InsecureSkipVerify: insecure,
+	}
+
+	if cafile != "" {
+		pool, err := LoadCertPool(cafile)
+		if err != nil {
+			return nil, err
+		}
+		tlsconfig.RootCAs = pool
+	}
+
+	if certfile != "" || keyfile != "" {
+		cert, err := tls.LoadX509KeyPair(certfile, keyfile)
+		if err != nil {
+			return nil, err
+		}
+		tlsconfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsconfig, nil
+}
+
+// ServerConfig is the TLS settings for serving an API.  With a client CA,
+// clients must present a certificate signed by it.
+func ServerConfig(clientca string) (*tls.Config, error) {
+	tlsconfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientca != "" {
+		pool, err := LoadCertPool(clientca)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsconfig.ClientCAs = pool
+		tlsconfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsconfig, nil
+}
+
+// HostTLS is how to reach an agent over https.  CertFile/KeyFile are only
+// needed when the agent requires client certificates.
+type HostTLS struct {
+	Enabled            bool   `yaml:"Enabled"`
+	CAFile             string `yaml:"CAFile"`
+	CertFile           string `yaml:"CertFile"`
+	KeyFile            string `yaml:"KeyFile"`
+	ServerName         string `yaml:"ServerName"`
+	InsecureSkipVerify bool   `yaml:"InsecureSkipVerify"`
+}
+
+func (t HostTLS) Scheme() string {
+	if t.Enabled {
+		return "https://"
+	}
+
+	return "http://"
+}
+
+func (t HostTLS) Client() (*http.Client, error) {
+	if ! t.Enabled {
+		return &http.Client{Timeout: ClientTimeout}, nil
+	}
+
+	tlsconfig, err := ClientConfig(t.CAFile, t.CertFile, t.KeyFile, t.ServerName, t.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{
+		Timeout:   ClientTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsconfig},
+	}
+
+	return client, nil
+}
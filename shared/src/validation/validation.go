@@ -0,0 +1,129 @@
+// Package validation holds what the validate subcommands of the Heimdall
+// binaries have in common: the error type, turning yaml errors into
+// file:line messages, finding the line a setting is on and printing the
+// report.
+package validation
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Error is one problem found by `validate`.  Line is 0 when the problem
+// isn't tied to one spot in the file, e.g. a missing setting.
+type Error struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (e Error) String() string {
+	if e.Line > 0 {
+		return e.File + ":" + strconv.Itoa(e.Line) + ": " + e.Message
+	}
+
+	return e.File + ": " + e.Message
+}
+
+var yamlLineRe = regexp.MustCompile(`line (\d+): (.*)`)
+
+// YAMLErrors splits a yaml error, which can hold several "line N: ..."
+// messages, into one Error per line.
+func YAMLErrors(file string, err error) []Error {
+	var errs []Error
+
+	for _, msg := range strings.Split(err.Error(), "\n") {
+		m := yamlLineRe.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(m[1])
+		errs = append(errs, Error{File: file, Line: line, Message: m[2]})
+	}
+
+	if len(errs) == 0 {
+		errs = append(errs, Error{File: file, Message: err.Error()})
+	}
+
+	return errs
+}
+
+// LineOf returns the line of the first "key: value", or of the first "key:"
+// when value is empty, or 0 if there isn't one.  A key of "-" finds a list
+// item.
+func LineOf(data []byte, key string, value string) int {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+
+		if key == "-" {
+			if strings.HasPrefix(text, "-") && strings.Trim(strings.TrimSpace(text[1:]), `"'`) == value {
+				return line
+			}
+			continue
+		}
+
+		text = strings.TrimLeft(text, " \t-")
+		if !strings.HasPrefix(text, key + ":") {
+			continue
+		}
+		if value == "" || strings.Trim(strings.TrimSpace(text[len(key)+1:]), `"'`) == value {
+			return line
+		}
+	}
+
+	return 0
+}
+
+// Unknown reports the names in list that aren't in known, e.g. plugin names
+// that don't match a loaded plugin.  what says what the names are.
+func Unknown(file string, data []byte, what string, known []string, list []string) []Error {
+	var errs []Error
+
+	found := make(map[string]bool)
+	for _, name := range known {
+		found[name] = true
+	}
+
+	sorted := append([]string(nil), known...)
+	sort.Strings(sorted)
+
+	for _, name := range list {
+		if !found[name] {
+			errs = append(errs, Error{File: file, Line: LineOf(data, "-", name), Message: "unknown " + what + " " + strconv.Quote(name) + " (loaded: " + strings.Join(sorted, ", ") + ")"})
+		}
+	}
+
+	return errs
+}
+
+// Report prints errs as file:line: message, in file and line order, and
+// returns the exit status for the validate subcommand.  checked is how many
+// files were looked at.
+func Report(errs []Error, checked int) int {
+	sort.SliceStable(errs, func(i, j int) bool {
+		if errs[i].File != errs[j].File {
+			return errs[i].File < errs[j].File
+		}
+		return errs[i].Line < errs[j].Line
+	})
+
+	for _, e := range errs {
+		fmt.Println(e.String())
+	}
+
+	if len(errs) > 0 {
+		fmt.Println(strconv.Itoa(len(errs)) + " problem(s) found")
+		return 1
+	}
+
+	fmt.Println(strconv.Itoa(checked) + " config file(s) OK")
+	return 0
+}
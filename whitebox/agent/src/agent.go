@@ -1,13 +1,20 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"encoding/json"
 	"os"
+	"schedule"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 	"worker"
+	"logger"
+	"service"
 	"github.com/gorilla/mux"
 	"gopkg.in/yaml.v2"
 )
@@ -19,18 +26,112 @@ type Config struct {
 	CheckFreq   int      `yaml:"CheckFreq"`
 	Params      []string `yaml:"Params"`
 	Enabled     bool     `yaml:"Enabled"`
+	Warning     float64  `yaml:"Warning"`
+	Critical    float64  `yaml:"Critical"`
+	ParamThresholds map[string]Threshold `yaml:"ParamThresholds"`
+	Timeout     int      `yaml:"Timeout"`
+	MaxOutput   int      `yaml:"MaxOutput"`
+	RunOnStart  *bool    `yaml:"RunOnStart"`
+	Splay       int      `yaml:"Splay"`
+	Jitter      int      `yaml:"Jitter"`
+	Schedule    string   `yaml:"Schedule"`
+	Tags        map[string]string `yaml:"Tags"`
+	DependsOn   []string `yaml:"DependsOn"`
+	FlapLow     float64  `yaml:"FlapLow"`
+	FlapHigh    float64  `yaml:"FlapHigh"`
+	ReportMode  string   `yaml:"ReportMode"`
+	Heartbeat   int      `yaml:"Heartbeat"`
+	Freshness   int      `yaml:"Freshness"`
+	File        string   `yaml:"-"`
 }
 
+// AgentConfig holds settings for the agent as a whole, as opposed to the
+// per check Configs in config.d.  The file is optional.  Environment,
+// Datacenter, Role, Team and Tags describe this host and are attached to
+// every result (see ResultTags).
+type AgentConfig struct {
+	Environment string            `yaml:"Environment"`
+	Datacenter  string            `yaml:"Datacenter"`
+	Role        string            `yaml:"Role"`
+	Team        string            `yaml:"Team"`
+	Tags        map[string]string `yaml:"Tags"`
+	Push      PushConfig `yaml:"Push"`
+	Auth      AuthConfig `yaml:"Auth"`
+	MaxBuffer int        `yaml:"MaxBuffer"`
+	Consumers []string   `yaml:"Consumers"`
+	Maintenance []MaintenanceWindow `yaml:"Maintenance"`
+}
+
+// PushConfig turns on push mode, where results are POSTed to a scraper's
+// /ingest endpoint instead of waiting to be scraped.  The scraper only takes
+// pushes with this host's Token (its PushToken there), or signed with its
+// Secret (its PushSecret) under the host name.  Interval and MaxBackoff are
+// in seconds.  The TLS settings are used for https URLs.
+type PushConfig struct {
+	URL                string `yaml:"URL"`
+	Token              string `yaml:"Token"`
+	Secret             string `yaml:"Secret"`
+	Interval           int    `yaml:"Interval"`
+	BatchSize          int    `yaml:"BatchSize"`
+	MaxBackoff         int    `yaml:"MaxBackoff"`
+	CAFile             string `yaml:"CAFile"`
+	CertFile           string `yaml:"CertFile"`
+	KeyFile            string `yaml:"KeyFile"`
+	ServerName         string `yaml:"ServerName"`
+	InsecureSkipVerify bool   `yaml:"InsecureSkipVerify"`
+}
+
+// Threshold overrides the label wide Warning/Critical values for a single
+// Param, e.g. a disk path that normally runs fuller than the others.
+type Threshold struct {
+	Warning  float64 `yaml:"Warning"`
+	Critical float64 `yaml:"Critical"`
+}
+
+var Version = "0.1"
+
+var opts service.Options
+var configDir string
+var agentConfigFile string
+
 var configs []Config
+var agentconfig AgentConfig
 var checks []worker.Check
 
+// latest holds the most recent result for every check, keyed by latestKey.
+// Unlike checks it isn't emptied by /checkandclear, so /metrics always has
+// something to report.
+var latest = make(map[string]worker.Check)
+var checksLock sync.Mutex
+
+// chanl carries results from every running Do_Checks goroutine to the
+// collector in main.
+var chanl = make(chan worker.Check)
+
+// ParseFlags fills in the settings above.  Every flag can also be set from
+// the environment, the flag wins if both are given.
+func ParseFlags() {
+	showversion := false
+
+	opts.Flags(":9050", "./heimdall.log")
+	flag.StringVar(&configDir, "config-dir", service.EnvOr("HEIMDALL_CONFIG_DIR", "/etc/heimdall/config.d/"), "directory holding the check configs (HEIMDALL_CONFIG_DIR)")
+	flag.StringVar(&agentConfigFile, "agent-config", service.EnvOr("HEIMDALL_AGENT_CONFIG", "/etc/heimdall/agent.yml"), "agent wide settings file (HEIMDALL_AGENT_CONFIG)")
+	flag.BoolVar(&showversion, "version", false, "print the version and exit")
+	flag.Parse()
+
+	if showversion {
+		fmt.Println("Heimdall Agent " + Version)
+		os.Exit(0)
+	}
+}
+
 func MakeSkel() error {
-	err := os.MkdirAll("/etc/heimdall/config.d", 0644)
+	err := os.MkdirAll(configDir, 0644)
 	if err != nil {
 		return err
 	}
 
-	file, err := os.OpenFile("/etc/heimdall/config.d/cpu.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(filepath.Join(configDir, "cpu.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("Label: Load Average\n")
 	file.WriteString("CommandType: internal\n")
 	file.WriteString("Command: LoadAverage\n")
@@ -38,42 +139,52 @@ func MakeSkel() error {
 	file.WriteString("Enabled: true\n")
 	file.Close()
 
-	file, err = os.OpenFile("/etc/heimdall/config.d/memory.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err = os.OpenFile(filepath.Join(configDir, "memory.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("Label: Memory Usage\n")
 	file.WriteString("CommandType: internal\n")
 	file.WriteString("Command: MemUsage\n")
 	file.WriteString("CheckFreq: 60\n")
+	file.WriteString("Warning: 80\n")
+	file.WriteString("Critical: 90\n")
 	file.WriteString("Enabled: true\n")
 	file.Close()
 
-	file, err = os.OpenFile("/etc/heimdall/config.d/disks.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err = os.OpenFile(filepath.Join(configDir, "disks.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("Label: Disk usage\n")
 	file.WriteString("CommandType: internal\n")
 	file.WriteString("Command: CheckDiskUsage\n")
 	file.WriteString("CheckFreq: 60\n")
 	file.WriteString("Params: [\"/\", \"/home\", \"/proj/app\", \"/tmp\", \"/var\"]\n")
+	file.WriteString("Warning: 80\n")
+	file.WriteString("Critical: 90\n")
+	file.WriteString("ParamThresholds:\n")
+	file.WriteString("  /tmp:\n")
+	file.WriteString("    Warning: 90\n")
+	file.WriteString("    Critical: 95\n")
 	file.WriteString("Enabled: true\n")
 	file.Close()
 
-	file, err = os.OpenFile("/etc/heimdall/config.d/rootpasswdexp.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err = os.OpenFile(filepath.Join(configDir, "rootpasswdexp.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("Label: Root Password Expiration\n")
 	file.WriteString("CommandType: internal\n")
 	file.WriteString("Command: CheckPassword\n")
 	file.WriteString("CheckFreq: 60\n")
 	file.WriteString("Params: [\"root\"]\n")
+	file.WriteString("Schedule: \"0 6 * * *\"\n")
 	file.WriteString("Enabled: true\n")
 	file.Close()
 
-	file, err = os.OpenFile("/etc/heimdall/config.d/oraclepasswdexp.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err = os.OpenFile(filepath.Join(configDir, "oraclepasswdexp.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("Label: Oracle Password Expiration\n")
 	file.WriteString("CommandType: internal\n")
 	file.WriteString("Command: CheckPassword\n")
 	file.WriteString("CheckFreq: 60\n")
 	file.WriteString("Params: [\"oracle\"]\n")
+	file.WriteString("Schedule: \"0 6 * * *\"\n")
 	file.WriteString("Enabled: true\n")
 	file.Close()
 
-	file, err = os.OpenFile("/etc/heimdall/config.d/ssh.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err = os.OpenFile(filepath.Join(configDir, "ssh.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("Label: Check SSH\n")
 	file.WriteString("CommandType: internal\n")
 	file.WriteString("Command: CheckSSH\n")
@@ -81,98 +192,136 @@ func MakeSkel() error {
 	file.WriteString("Enabled: true\n")
 	file.Close()
 
-	file, err = os.OpenFile("/etc/heimdall/config.d/swap.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err = os.OpenFile(filepath.Join(configDir, "swap.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("Label: Swap Usage\n")
 	file.WriteString("CommandType: internal\n")
 	file.WriteString("Command: CheckSwap\n")
 	file.WriteString("CheckFreq: 60\n")
+	file.WriteString("Warning: 50\n")
+	file.WriteString("Critical: 80\n")
 	file.WriteString("Enabled: true\n")
 	file.Close()
 
-	file, err = os.OpenFile("/etc/heimdall/config.d/inode.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err = os.OpenFile(filepath.Join(configDir, "inode.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("Label: Inode Usage\n")
 	file.WriteString("CommandType: internal\n")
 	file.WriteString("Command: CheckInodes\n")
 	file.WriteString("CheckFreq: 60\n")
+	file.WriteString("Params: [\"/\", \"/home\", \"/tmp\", \"/var\"]\n")
+	file.WriteString("Warning: 80\n")
+	file.WriteString("Critical: 90\n")
 	file.WriteString("Enabled: true\n")
 	file.Close()
 
-	file, err = os.OpenFile("/etc/heimdall/config.d/ntp.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err = os.OpenFile(filepath.Join(configDir, "ntp.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("Label: NTP Skew\n")
 	file.WriteString("CommandType: internal\n")
 	file.WriteString("Command: CheckNTPSkew\n")
 	file.WriteString("CheckFreq: 60\n")
+	file.WriteString("Warning: 0.5\n")
+	file.WriteString("Critical: 1\n")
 	file.WriteString("Params: [\"pool.ntp.org\"]\n")
 	file.WriteString("Enabled: true\n")
 	file.Close()
 
-	file, err = os.OpenFile("/etc/heimdall/config.d/mailq.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err = os.OpenFile(filepath.Join(configDir, "mailq.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("Label: MailQ Count\n")
 	file.WriteString("CommandType: internal\n")
 	file.WriteString("Command: CheckMailQ\n")
 	file.WriteString("CheckFreq: 60\n")
+	file.WriteString("Warning: 50\n")
+	file.WriteString("Critical: 100\n")
 	file.WriteString("Enabled: true\n")
 	file.Close()
 
 	return nil
 }
 
-func Log(message string) {
-	file, err := os.OpenFile("./heimdall.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println("Failed To Open Log File: " + err.Error())
-	}
-	file.Close()
-
-	current_time := time.Now().Local()
-	t := current_time.Format("Jan 02 2006 03:04:05")
-	_, err = file.WriteString(t + " - Heimdall: " + message + "\n")
+// Log writes an informational message.  Warnings and errors go through the
+// logger package directly so they carry the right level.
+func Log(message string, fields ...logger.Fields) {
+	logger.Info(message, fields...)
 }
 
-func GetConfigs() {
+// GetConfigs loads every definition in config.d.  Files that can't be read
+// or parsed are returned in failed, keyed by file name, so the reload can
+// keep what was loaded from them before.  An error reading config.d itself
+// is returned so callers don't mistake it for an empty directory and stop
+// every check.
+func GetConfigs() ([]Config, map[string]error, error) {
+	var loaded []Config
+	failed := make(map[string]error)
 
-	_, err := os.Stat("/etc/heimdall/config.d/")
+	_, err := os.Stat(configDir)
 	if os.IsNotExist(err) {
 		err := MakeSkel()
 		if err != nil {
-			fmt.Println("Error Setting Up /etc/heimdall/config.d/ and default settings file: " + err.Error())
-			Log("Error Setting Up /etc/heimdall/config.d/ and default settings file: " + err.Error())
-			return
+			fmt.Println("Error Setting Up " + configDir + " and default settings file: " + err.Error())
+			logger.Error("Error Setting Up " + configDir + " and default settings file: " + err.Error())
+			return nil, nil, err
 		}
 	}
 
-	files, err := ioutil.ReadDir("/etc/heimdall/config.d/")
+	files, err := ioutil.ReadDir(configDir)
 	if err != nil {
-		fmt.Println("Error Reading /etc/heimdall/config.d/: " + err.Error())
-		Log("Error Reading /etc/heimdall/config.d/: " + err.Error())
-		return
+		fmt.Println("Error Reading " + configDir + ": " + err.Error())
+		logger.Error("Error Reading " + configDir + ": " + err.Error())
+		return nil, nil, err
 	}
 
 	if len(files) < 1 {
-		fmt.Println("/etc/heimdall/config.d/ exists, but is empty. No Configs Loaded")
-		Log("/etc/heimdall/config.d/ exists, but is empty. No Configs Loaded")
+		fmt.Println(configDir + " exists, but is empty. No Configs Loaded")
+		logger.Warn(configDir + " exists, but is empty. No Configs Loaded")
 	}
 
 	for _, f := range files {
-		b, err := ioutil.ReadFile("/etc/heimdall/config.d/" + f.Name())
+		b, err := ioutil.ReadFile(filepath.Join(configDir, f.Name()))
 		if err != nil {
-			fmt.Println("Error Opening File: /etc/heimdall/config.d/" + f.Name() + ": " + err.Error())
-			Log("Error Opening File: /etc/heimdall/config.d/" + f.Name() + ": " + err.Error())
+			fmt.Println("Error Opening File: " + filepath.Join(configDir, f.Name()) + ": " + err.Error())
+			logger.Error("Error Opening File: " + filepath.Join(configDir, f.Name()) + ": " + err.Error())
+			failed[f.Name()] = err
+			continue
 		}
 
 		yml := string(b)
 
 		c := Config{}
 		err = yaml.Unmarshal([]byte(yml), &c)
+		c.File = f.Name()
 
 		if err != nil {
-			fmt.Println("Couldn't Parse YAML File /etc/heimdall/config.d/" + f.Name() + ": " + err.Error())
-			Log("Couldn't Parse YAML File /etc/heimdall/config.d/" + f.Name() + ": " + err.Error())
+			fmt.Println("Couldn't Parse YAML File " + filepath.Join(configDir, f.Name()) + ": " + err.Error())
+			logger.Error("Couldn't Parse YAML File " + filepath.Join(configDir, f.Name()) + ": " + err.Error())
+			failed[f.Name()] = err
+			continue
 		}
 
-		configs = append(configs, c)
+		loaded = append(loaded, c)
+	}
+
+	return loaded, failed, nil
+}
+
+func GetAgentConfig() AgentConfig {
+	ac := AgentConfig{}
+
+	b, err := ioutil.ReadFile(agentConfigFile)
+	if os.IsNotExist(err) {
+		return ac
+	} else if err != nil {
+		fmt.Println("Error Opening File: " + agentConfigFile + ": " + err.Error())
+		logger.Error("Error Opening File: " + agentConfigFile + ": " + err.Error())
+		return ac
+	}
+
+	err = yaml.Unmarshal(b, &ac)
+	if err != nil {
+		fmt.Println("Couldn't Parse YAML File " + agentConfigFile + ": " + err.Error())
+		logger.Error("Couldn't Parse YAML File " + agentConfigFile + ": " + err.Error())
+		return AgentConfig{}
 	}
 
+	return ac
 }
 
 func handleWhoAreYou(w http.ResponseWriter, r *http.Request) {
@@ -183,15 +332,58 @@ func handlePing(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "pong")
 }
 
+// handleChecks returns buffered results without removing them.  With
+// ?since=<seq>, or ?consumer=<name> to start from that consumer's last
+// acknowledgement, only newer results are returned.  ?limit= pages the
+// results, see NextPageHeader.
 func handleChecks(w http.ResponseWriter, r *http.Request) {
-	jsn, _ := json.Marshal(checks)
-	fmt.Fprintf(w, string(jsn))
+	since, err := parseSince(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit, err := parseLimit(r)
+	if err != nil {
+		http.Error(w, "bad limit: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	checksLock.Lock()
+	list := checksSince(since)
+	checksLock.Unlock()
+
+	list, next := page(list, limit)
+	writeChecks(w, r, list, next)
 }
 
+// handleCheckAndClear returns the buffered results and removes them.  With
+// ?limit= only the oldest limit results are returned and removed.  It's
+// refused while consumers are registered, as clearing would throw away
+// results they haven't acknowledged.
 func handleCheckAndClear(w http.ResponseWriter, r *http.Request) {
-	jsn, _ := json.Marshal(checks)
-	fmt.Fprintf(w, string(jsn))
-	checks = nil
+	limit, err := parseLimit(r)
+	if err != nil {
+		http.Error(w, "bad limit: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	checksLock.Lock()
+	if len(cursors) > 0 {
+		checksLock.Unlock()
+		http.Error(w, "consumers are registered, read /checks?consumer=<name> and /ack instead", http.StatusConflict)
+		return
+	}
+
+	list, next := page(checks, limit)
+	if next != "" {
+		checks = append([]worker.Check(nil), checks[len(list):]...)
+	} else {
+		checks = nil
+	}
+	checksLock.Unlock()
+
+	writeChecks(w, r, list, next)
 }
 
 func handleStatusOf(w http.ResponseWriter, r *http.Request) {
@@ -202,82 +394,283 @@ func handleStatusOf(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	param := r.URL.Query().Get("param")
+
+	checksLock.Lock()
 	for _, chk := range checks {
-		if chk.ConfigLabel == check {
+		if chk.ConfigLabel == check && (param == "" || chk.Param == param) {
 			retvals = append(retvals, chk)
 		}
 	}
+	checksLock.Unlock()
 
 	jsn, _ := json.Marshal(retvals)
-	fmt.Fprintf(w, string(jsn))
+	w.Write(jsn)
 }
 
-func Do_Checks(c *Config, chanl chan worker.Check) {
-	var check worker.Check
+func handleCheckTypes(w http.ResponseWriter, r *http.Request) {
+	jsn, _ := json.Marshal(worker.CheckTypes())
+	fmt.Fprintf(w, "%s", jsn)
+}
 
-	if c.CheckFreq < 1 {
-		c.CheckFreq = 60
+// ExecOptions is how external and nagios commands are run.  Timeout is in
+// seconds, MaxOutput in bytes; 0 leaves the worker defaults in place.
+// Params aren't passed to external commands, only to nagios plugins.
+func (c *Config) ExecOptions() worker.ExecOptions {
+	return worker.ExecOptions{
+		Timeout:   time.Duration(c.Timeout) * time.Second,
+		MaxOutput: c.MaxOutput,
 	}
+}
 
-	for {
-		time.Sleep(time.Duration(c.CheckFreq) * time.Second)
-		if c.CommandType == "internal" {
-			if c.Command == "LoadAverage" {
-				check, _ = worker.LoadAverage(c.Label)
-			} else if c.Command == "MemUsage" {
-				check, _ = worker.MemUsage(c.Label)
-			} else if c.Command == "CheckSSH" {
-				check, _ = worker.CheckSSH(c.Label)
-			} else if c.Command == "CheckSwap" {
-				check, _ = worker.CheckSwap(c.Label)
-			} else if c.Command == "CheckMailQ" {
-				check, _ = worker.CheckMailQ(c.Label)
-			} else if c.Command == "CheckDiskUsage" {
-				for _, dir := range c.Params {
-					check, _ = worker.CheckDiskUsage(c.Label, dir)
-				}
-			} else if c.Command == "CheckPassword" {
-				for _, user := range c.Params {
-					check, _ = worker.CheckPassword(c.Label, user)
-				}
-			} else if c.Command == "CheckNTPSkew" {
-				for _, ntpserver := range c.Params {
-					check, _ = worker.CheckNTPSkew(c.Label, ntpserver)
-				}
-			} else if c.Command == "FindFilePerms" {
-				check, _ = worker.FindFilePerms(c.Label, c.Params[0], c.Params[1], c.Params[2])
+func (c *Config) Thresholds(param string) (float64, float64) {
+	if t, ok := c.ParamThresholds[param]; ok {
+		return t.Warning, t.Critical
+	}
+
+	return c.Warning, c.Critical
+}
+
+// EvaluateState applies the configured thresholds to a check the worker
+// reported as OK.  A threshold of 0 means it isn't set.
+func EvaluateState(c *Config, param string, check *worker.Check) {
+	if check.State == "" {
+		if check.Retval == 0 {
+			check.State = worker.StateOK
+		} else {
+			check.State = worker.StateUnknown
+		}
+	}
+
+	if check.State != worker.StateOK {
+		return
+	}
+
+	warning, critical := c.Thresholds(param)
+	if critical > 0 && check.Value >= critical {
+		check.State = worker.StateCritical
+	} else if warning > 0 && check.Value >= warning {
+		check.State = worker.StateWarning
+	}
+}
+
+// RunCheck runs the check described by c once and returns the results with
+// their state and host filled in.  Checks that take one Param at a time
+// (disk paths, users, NTP servers) give one result per Param, the rest give
+// a single result.  If a check c DependsOn isn't OK, c isn't run and its
+// results are UNKNOWN and Skipped instead.
+func RunCheck(c *Config) []worker.Check {
+	var results []worker.Check
+
+	if parent, state := FailedDependency(c); parent != "" {
+		params := []string{""}
+		if ct, ok := worker.Lookup(c.Command); ok && c.CommandType == "internal" && ct.PerParam && len(c.Params) > 0 {
+			params = c.Params
+		}
+
+		for _, p := range params {
+			check := worker.ErrorCheck(c.Label, c.Command, "skipped, depends on " + parent + " which is " + state)
+			check.Param = p
+			check.Skipped = true
+			check.SkipReason = parent + " is " + state
+			results = append(results, check)
+		}
+	} else if c.CommandType == "internal" {
+		ct, ok := worker.Lookup(c.Command)
+		if !ok {
+			results = append(results, worker.ErrorCheck(c.Label, c.Command, "unknown check command: " + c.Command))
+		} else if err := ct.ValidateParams(c.Params); err != nil {
+			results = append(results, worker.ErrorCheck(c.Label, c.Command, err.Error()))
+		} else if ct.PerParam {
+			for _, p := range c.Params {
+				check, _ := ct.Run(c.Label, []string{p})
+				check.Param = p
+				results = append(results, check)
 			}
 		} else {
-			check, _ = worker.RunExternal(c.Label, c.Command)
+			check, _ := ct.Run(c.Label, c.Params)
+			results = append(results, check)
+		}
+	} else if c.CommandType == "passive" {
+		results = append(results, worker.ErrorCheck(c.Label, c.Command, "passive check, results are submitted to /submit"))
+	} else if c.CommandType == "nagios" {
+		opts := c.ExecOptions()
+		opts.Args = c.Params
+		check, _ := worker.RunNagios(c.Label, c.Command, opts)
+		results = append(results, check)
+	} else {
+		check, _ := worker.RunExternal(c.Label, c.Command, c.ExecOptions())
+		results = append(results, check)
+	}
+
+	hstname, err := os.Hostname()
+	if err != nil {
+		hstname = "Error Getting Hostname: " + err.Error()
+	}
+
+	for i := range results {
+		EvaluateState(c, results[i].Param, &results[i])
+		results[i].Host = hstname
+		results[i].Tags = c.ResultTags()
+	}
+
+	return results
+}
+
+// ResultTags are the tags a result of c carries: the host's Environment,
+// Datacenter, Role and Team, then the host's Tags, then c's own Tags, each
+// overriding the ones before.
+func (c *Config) ResultTags() map[string]string {
+	tags := make(map[string]string)
+
+	for k, v := range map[string]string{
+		"environment": agentconfig.Environment,
+		"datacenter":  agentconfig.Datacenter,
+		"role":        agentconfig.Role,
+		"team":        agentconfig.Team,
+	} {
+		if v != "" {
+			tags[k] = v
 		}
+	}
+	for k, v := range agentconfig.Tags {
+		tags[k] = v
+	}
+	for k, v := range c.Tags {
+		tags[k] = v
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
 
-		hstname, err := os.Hostname()
+	return tags
+}
+
+// Scheduler returns when the check runs: the cron expression in Schedule if
+// there is one, otherwise every CheckFreq seconds.  Jitter seconds of random
+// delay are added to every run.
+func (c *Config) Scheduler() (schedule.Schedule, error) {
+	jitter := time.Duration(c.Jitter) * time.Second
+
+	if c.Schedule != "" {
+		cron, err := schedule.ParseCron(c.Schedule)
 		if err != nil {
-			check.Host = "Error Getting Hostname: " + err.Error()
-		} else {
-			check.Host = hstname
+			return nil, err
 		}
+		cron.Jitter = jitter
+		return cron, nil
+	}
 
-		chanl<-check
+	freq := c.CheckFreq
+	if freq < 1 {
+		freq = 60
 	}
+
+	return schedule.Interval{Every: time.Duration(freq) * time.Second, Jitter: jitter}, nil
 }
 
-func main() {
-	GetConfigs()
+// Do_Checks runs c on its schedule until stop is closed.  Unless RunOnStart
+// is false the first run happens straight away, after up to Splay seconds of
+// random delay.
+func Do_Checks(c *Config, chanl chan worker.Check, stop chan struct{}) {
+	// Passive checks are never run, their results come in through /submit
+	if c.CommandType == "passive" {
+		Do_Passive(c, chanl, stop)
+		return
+	}
 
-	go func() {
+	sched, err := c.Scheduler()
+	if err != nil {
+		fmt.Println("Not Scheduling " + c.Label + ": " + err.Error())
+		logger.Error("Not Scheduling " + c.Label + ": " + err.Error(), logger.Fields{"label": c.Label})
+		chanl<-worker.ErrorCheck(c.Label, c.Command, "bad schedule: " + err.Error())
+		return
+	}
+
+	next := time.Now().Add(schedule.RandomDuration(time.Duration(c.Splay) * time.Second))
+	if c.RunOnStart != nil && !*c.RunOnStart {
+		next = sched.Next(next)
+	}
 
-		chanl := make(chan worker.Check)
-		for i := 0; i < len(configs); i++ {
-			c := configs[i]
-			if c.Enabled {
-				go Do_Checks(&c, chanl)
+	for {
+		if next.IsZero() {
+			logger.Warn("Schedule For " + c.Label + " Never Matches, Not Running It", logger.Fields{"label": c.Label})
+			<-stop
+			return
+		}
+
+		ScheduledRun(c.Label, next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		StartedRun(c.Label)
+		results := RunCheck(c)
+		next = sched.Next(time.Now())
+		FinishedRun(c.Label, results, next)
+		logger.Debug("Ran " + c.Label + ": " + strconv.Itoa(len(results)) + " result(s)", logger.Fields{"label": c.Label})
+
+		for _, check := range results {
+			if !TrackState(c, &check) {
+				UpdateLatest(check)
+				continue
+			}
+
+			select {
+			case chanl<-check:
+			case <-stop:
+				return
 			}
 		}
+	}
+}
+
+func main() {
+	// "agent validate [flags]" checks the configs and exits.
+	validate := len(os.Args) > 1 && os.Args[1] == "validate"
+	if validate {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	ParseFlags()
 
+	if validate {
+		os.Exit(Validate())
+	}
+
+	err := opts.InitLogging("agent")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed To Set Up Logging, Using stderr: " + err.Error())
+	}
+
+	err = opts.WritePidFile()
+	if err != nil {
+		fmt.Println("Failed To Write PID File: " + err.Error())
+		logger.Error("Failed To Write PID File: " + err.Error())
+	}
+
+	agentconfig = GetAgentConfig()
+	verifier.MaxSkew = time.Duration(agentconfig.Auth.MaxSkew) * time.Second
+	RegisterConsumers(agentconfig.Consumers)
+	_, err = ReloadConfigs("startup")
+	if err != nil {
+		fmt.Println("Starting With No Checks, Retrying Every " + configWatchInterval.String() + ": " + err.Error())
+	}
+	go WatchConfigs(err != nil)
+
+	if agentconfig.Push.URL != "" {
+		go Do_Push(agentconfig.Push)
+	}
+
+	go func() {
 		for {
-			tmp := <-chanl
-			checks = append(checks, tmp)
+			RecordCheck(<-chanl)
 		}
 		
 	}()
@@ -285,11 +678,24 @@ func main() {
 	router := mux.NewRouter()
 	router.HandleFunc("/whoareyou", handleWhoAreYou)
 	router.HandleFunc("/ping", handlePing)
-	router.HandleFunc("/checks", handleChecks)
-	router.HandleFunc("/checkandclear", handleCheckAndClear)
-	router.HandleFunc("/statusof", handleStatusOf)
-
-	err := http.ListenAndServe(":9050", router)
+	router.HandleFunc("/checks", requireScope(ScopeRead, handleChecks))
+	router.HandleFunc("/checkandclear", requireScope(ScopeConsume, handleCheckAndClear))
+	router.HandleFunc("/ack", requireScope(ScopeConsume, handleAck)).Methods("POST")
+	router.HandleFunc("/statusof", requireScope(ScopeRead, handleStatusOf))
+	router.HandleFunc("/metrics", requireScope(ScopeRead, handleMetrics))
+	router.HandleFunc("/reload", requireScope(ScopeAdmin, handleReload)).Methods("POST")
+	router.HandleFunc("/reload", requireScope(ScopeRead, handleReload))
+	router.HandleFunc("/checktypes", requireScope(ScopeRead, handleCheckTypes))
+	router.HandleFunc("/run", requireScope(ScopeAdmin, handleRun)).Methods("POST")
+	router.HandleFunc("/submit", requireScope(ScopeSubmit, handleSubmit)).Methods("POST")
+	router.HandleFunc("/status", requireScope(ScopeRead, handleStatus))
+	router.HandleFunc("/dependencies", requireScope(ScopeRead, handleDependencies))
+	router.HandleFunc("/config", requireScope(ScopeAdmin, handleConfig)).Methods("POST", "PUT", "DELETE")
+	router.HandleFunc("/config", requireScope(ScopeRead, handleConfig))
+	router.HandleFunc("/silence", requireScope(ScopeAdmin, handleSilence)).Methods("POST", "DELETE")
+	router.HandleFunc("/silence", requireScope(ScopeRead, handleSilence))
+
+	err = opts.Serve(router)
 	if err != nil {
 		fmt.Println("ListenAndServe: ", err)
 	}
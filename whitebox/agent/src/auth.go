@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"logger"
+	"net/http"
+	"signing"
+	"strings"
+)
+
+// Scopes, each one allowing everything the ones before it do.  read covers
+// looking at results, consume also allows clearing them, admin allows
+// changing what the agent does.  submit stands apart: it only allows sending
+// passive results to /submit, which admin can do too, so a local script's
+// credential can't read or clear the buffer.
+const (
+	ScopeRead    = "read"
+	ScopeConsume = "consume"
+	ScopeAdmin   = "admin"
+	ScopeSubmit  = "submit"
+)
+
+var scopeLevels = map[string]int{
+	ScopeSubmit:  0,
+	ScopeRead:    1,
+	ScopeConsume: 2,
+	ScopeAdmin:   3,
+}
+
+// allowed says whether a credential with scope have may use an endpoint
+// that needs scope want.
+func allowed(have string, want string) bool {
+	if want == ScopeSubmit {
+		return have == ScopeSubmit || have == ScopeAdmin
+	}
+
+	return scopeLevels[have] >= scopeLevels[want]
+}
+
+type AuthConfig struct {
+	Credentials []Credential `yaml:"Credentials"`
+	MaxSkew     int          `yaml:"MaxSkew"`
+}
+
+type Credential struct {
+	Name   string `yaml:"Name"`
+	Token  string `yaml:"Token"`
+	Secret string `yaml:"Secret"`
+	Scope  string `yaml:"Scope"`
+}
+
+// verifier checks signed requests, remembering their nonces so they can't
+// be replayed.  main sets its MaxSkew from the agent config.
+var verifier = &signing.Verifier{}
+
+// authenticate returns the credential the request was made with.
+func authenticate(r *http.Request) (Credential, error) {
+	auth := agentconfig.Auth
+
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimPrefix(header, "Bearer ")
+		for _, cred := range auth.Credentials {
+			if cred.Token != "" && subtle.ConstantTimeCompare([]byte(cred.Token), []byte(token)) == 1 {
+				return cred, nil
+			}
+		}
+		return Credential{}, errors.New("unknown token")
+	}
+
+	keyname, err := verifier.Verify(r, func(keyname string) (string, bool) {
+		cred, ok := signingCredential(keyname)
+		return cred.Secret, ok
+	})
+	if err != nil {
+		return Credential{}, err
+	}
+
+	cred, _ := signingCredential(keyname)
+	return cred, nil
+}
+
+// signingCredential returns the credential with a Secret named keyname.
+func signingCredential(keyname string) (Credential, bool) {
+	for _, cred := range agentconfig.Auth.Credentials {
+		if cred.Name == keyname && cred.Secret != "" {
+			return cred, true
+		}
+	}
+
+	return Credential{}, false
+}
+
+type credentialKey struct{}
+
+// credentialOf returns the credential requireScope let r through with, as a
+// signed request can't be authenticated a second time.
+func credentialOf(r *http.Request) (Credential, bool) {
+	cred, ok := r.Context().Value(credentialKey{}).(Credential)
+	return cred, ok
+}
+
+// requireScope wraps a handler so it's only reachable with a credential of at
+// least the given scope.
+func requireScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(agentconfig.Auth.Credentials) == 0 {
+			handler(w, r)
+			return
+		}
+
+		cred, err := authenticate(r)
+		if err == signing.ErrTooLarge {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			logger.Debug("Unauthorized Request To " + r.URL.Path + ": " + err.Error())
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !allowed(cred.Scope, scope) {
+			logger.Warn("Credential " + cred.Name + " Denied " + scope + " Access To " + r.URL.Path)
+			http.Error(w, "forbidden: needs " + scope + " scope", http.StatusForbidden)
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), credentialKey{}, cred)))
+	}
+}
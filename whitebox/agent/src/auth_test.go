@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"signing"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func withAuth(t *testing.T, auth AuthConfig) {
+	saved := agentconfig
+	agentconfig.Auth = auth
+	t.Cleanup(func() { agentconfig = saved })
+}
+
+// sign signs req as the "signer" credential.  Every request needs its own
+// nonce, or it's taken for a replay.
+func sign(req *http.Request, timestamp string, nonce string, body string) {
+	req.Header.Set("X-Heimdall-Key", "signer")
+	req.Header.Set("X-Heimdall-Timestamp", timestamp)
+	req.Header.Set("X-Heimdall-Nonce", nonce)
+	req.Header.Set("X-Heimdall-Signature", signing.SignatureFor("sekrit", "POST", "/x", timestamp, nonce, []byte(body)))
+}
+
+func TestRequireScope(t *testing.T) {
+	withAuth(t, AuthConfig{Credentials: []Credential{
+		Credential{Name: "reader", Token: "readtoken", Scope: ScopeRead},
+		Credential{Name: "consumer", Token: "consumetoken", Scope: ScopeConsume},
+		Credential{Name: "signer", Secret: "sekrit", Scope: ScopeAdmin},
+		Credential{Name: "script", Token: "submittoken", Scope: ScopeSubmit},
+	}})
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	body := `{"Label":"x"}`
+
+	tests := []struct {
+		name   string
+		scope  string
+		header map[string]string
+		sign   string // timestamp to sign the request with, "" for none
+		tamper bool   // change the body after signing
+		want   int
+	}{
+		{"no credentials", ScopeRead, nil, "", false, http.StatusUnauthorized},
+		{"unknown token", ScopeRead, map[string]string{"Authorization": "Bearer nope"}, "", false, http.StatusUnauthorized},
+		{"token with the scope", ScopeRead, map[string]string{"Authorization": "Bearer readtoken"}, "", false, http.StatusOK},
+		{"token with a higher scope", ScopeRead, map[string]string{"Authorization": "Bearer consumetoken"}, "", false, http.StatusOK},
+		{"token with a lower scope", ScopeConsume, map[string]string{"Authorization": "Bearer readtoken"}, "", false, http.StatusForbidden},
+		{"submit token submitting", ScopeSubmit, map[string]string{"Authorization": "Bearer submittoken"}, "", false, http.StatusOK},
+		{"submit token reading", ScopeRead, map[string]string{"Authorization": "Bearer submittoken"}, "", false, http.StatusForbidden},
+		{"consume token submitting", ScopeSubmit, map[string]string{"Authorization": "Bearer consumetoken"}, "", false, http.StatusForbidden},
+		{"admin submitting", ScopeSubmit, nil, now, false, http.StatusOK},
+		{"signed", ScopeAdmin, nil, now, false, http.StatusOK},
+		{"signed too long ago", ScopeAdmin, nil, stale, false, http.StatusUnauthorized},
+		{"body changed after signing", ScopeAdmin, nil, now, true, http.StatusUnauthorized},
+		{"bad signature", ScopeAdmin, map[string]string{"X-Heimdall-Key": "signer", "X-Heimdall-Timestamp": now, "X-Heimdall-Nonce": "n1", "X-Heimdall-Signature": "00"}, "", false, http.StatusUnauthorized},
+		{"unknown key", ScopeAdmin, map[string]string{"X-Heimdall-Key": "nobody", "X-Heimdall-Timestamp": now, "X-Heimdall-Nonce": "n2", "X-Heimdall-Signature": signing.SignatureFor("sekrit", "POST", "/x", now, "n2", []byte(body))}, "", false, http.StatusUnauthorized},
+		{"no nonce", ScopeAdmin, map[string]string{"X-Heimdall-Key": "signer", "X-Heimdall-Timestamp": now, "X-Heimdall-Signature": signing.SignatureFor("sekrit", "POST", "/x", now, "", []byte(body))}, "", false, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen []byte
+			handler := requireScope(tt.scope, func(w http.ResponseWriter, r *http.Request) {
+				seen, _ = ioutil.ReadAll(r.Body)
+			})
+
+			req := httptest.NewRequest("POST", "/x", bytes.NewReader([]byte(body)))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			if tt.sign != "" {
+				sign(req, tt.sign, tt.name, body)
+			}
+			if tt.tamper {
+				req.Body = ioutil.NopCloser(bytes.NewReader([]byte(`{"Label":"y"}`)))
+			}
+
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+
+			// The signature check reads the body, the handler must still get it
+			if w.Code == http.StatusOK && string(seen) != body {
+				t.Errorf("handler read body %q, want %q", seen, body)
+			}
+		})
+	}
+}
+
+func TestRequireScopeAuthOff(t *testing.T) {
+	withAuth(t, AuthConfig{})
+
+	called := false
+	handler := requireScope(ScopeAdmin, func(w http.ResponseWriter, r *http.Request) { called = true })
+	handler(httptest.NewRecorder(), httptest.NewRequest("GET", "/x", nil))
+
+	if !called {
+		t.Error("with no Credentials configured every endpoint should be open")
+	}
+}
+
+func TestRequireScopeReplay(t *testing.T) {
+	withAuth(t, AuthConfig{Credentials: []Credential{
+		Credential{Name: "signer", Secret: "sekrit", Scope: ScopeAdmin},
+	}})
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	handler := requireScope(ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {})
+
+	send := func(nonce string, body string) int {
+		req := httptest.NewRequest("POST", "/x", bytes.NewReader([]byte(body)))
+		sign(req, now, nonce, body)
+
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	if code := send("replay-1", "{}"); code != http.StatusOK {
+		t.Fatalf("first request got %d, want 200", code)
+	}
+	if code := send("replay-1", "{}"); code != http.StatusUnauthorized {
+		t.Errorf("replayed request got %d, want 401", code)
+	}
+	if code := send("replay-2", "{}"); code != http.StatusOK {
+		t.Errorf("request with a new nonce got %d, want 200", code)
+	}
+
+	big := string(bytes.Repeat([]byte("x"), signing.DefaultMaxBody + 1))
+	if code := send("too-large", big); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized body got %d, want 413", code)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"logger"
+	"net/http"
+	"strconv"
+	"time"
+	"worker"
+)
+
+const DefaultMaxBuffer = 10000
+
+// How often a full buffer is logged, rather than on every result dropped.
+const bufferFullLogInterval = time.Minute
+
+var lastSeq uint64
+
+// cursors holds the last sequence number each consumer has acknowledged.
+// Results are only pruned once every consumer has acknowledged them.  Only
+// registered consumers have one, so a stray /ack can't hold results forever.
+var cursors = make(map[string]uint64)
+
+// Results dropped since the buffer full warning was last logged.
+var droppedSinceLog int
+var lastBufferFullLog time.Time
+
+// RecordCheck gives a result its sequence number and adds it to the buffer,
+// dropping the oldest results if the buffer is full.
+func RecordCheck(check worker.Check) worker.Check {
+	ApplySilences(&check)
+
+	checksLock.Lock()
+	defer checksLock.Unlock()
+
+	lastSeq++
+	check.Seq = lastSeq
+
+	checks = append(checks, check)
+	latest[latestKey(check)] = check
+
+	maxbuffer := agentconfig.MaxBuffer
+	if maxbuffer < 1 {
+		maxbuffer = DefaultMaxBuffer
+	}
+
+	if len(checks) > maxbuffer {
+		dropped := len(checks) - maxbuffer
+		checks = append([]worker.Check(nil), checks[dropped:]...)
+
+		droppedSinceLog += dropped
+		if time.Since(lastBufferFullLog) >= bufferFullLogInterval {
+			logger.Warn("Results Buffer Full, Dropped " + strconv.Itoa(droppedSinceLog) + " Unacknowledged Results")
+			droppedSinceLog = 0
+			lastBufferFullLog = time.Now()
+		}
+	}
+
+	return check
+}
+
+// UpdateLatest refreshes latest with a result that isn't being buffered.
+func UpdateLatest(check worker.Check) {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+
+	latest[latestKey(check)] = check
+}
+
+// latestKey tells apart the results of a check that reports once per Param.
+func latestKey(check worker.Check) string {
+	if check.Param == "" {
+		return check.ConfigLabel
+	}
+
+	return check.ConfigLabel + "\x00" + check.Param
+}
+
+// ForgetLatest drops label's results from latest, so a removed check or Param
+// stops showing up in /metrics.
+func ForgetLatest(label string) {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+
+	for key, check := range latest {
+		if check.ConfigLabel == label {
+			delete(latest, key)
+		}
+	}
+}
+
+// checksSince returns the buffered results newer than seq.  checksLock must
+// be held.
+func checksSince(seq uint64) []worker.Check {
+	for i, chk := range checks {
+		if chk.Seq > seq {
+			return append([]worker.Check(nil), checks[i:]...)
+		}
+	}
+
+	return []worker.Check{}
+}
+
+// Acknowledge moves consumer's cursor forward to seq and prunes anything
+// every consumer has now seen.  It returns how many results were pruned, and
+// false if consumer isn't registered.
+func Acknowledge(consumer string, seq uint64) (int, bool) {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+
+	if _, ok := cursors[consumer]; !ok {
+		return 0, false
+	}
+
+	if seq > cursors[consumer] {
+		cursors[consumer] = seq
+	}
+
+	oldest := cursors[consumer]
+	for _, acked := range cursors {
+		if acked < oldest {
+			oldest = acked
+		}
+	}
+
+	pruned := 0
+	for pruned < len(checks) && checks[pruned].Seq <= oldest {
+		pruned++
+	}
+	checks = append([]worker.Check(nil), checks[pruned:]...)
+
+	return pruned, true
+}
+
+// RegisterConsumers sets up cursors for consumers named in the agent config,
+// so nothing is pruned before they've had a chance to read it.
+func RegisterConsumers(consumers []string) {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+
+	for _, consumer := range consumers {
+		if _, ok := cursors[consumer]; !ok {
+			cursors[consumer] = 0
+		}
+	}
+}
+
+// parseSince works out where a /checks request starts from: a continuation
+// token or an explicit since, else the consumer's cursor, else everything.
+func parseSince(r *http.Request) (uint64, error) {
+	if token := r.URL.Query().Get("continue"); token != "" {
+		seq, err := strconv.ParseUint(token, 10, 64)
+		if err != nil {
+			return 0, errors.New("bad continue: " + err.Error())
+		}
+		return seq, nil
+	}
+
+	if since := r.URL.Query().Get("since"); since != "" {
+		seq, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return 0, errors.New("bad since: " + err.Error())
+		}
+		return seq, nil
+	}
+
+	if consumer := r.URL.Query().Get("consumer"); consumer != "" {
+		checksLock.Lock()
+		defer checksLock.Unlock()
+
+		seq, ok := cursors[consumer]
+		if !ok {
+			return 0, errUnknownConsumer(consumer)
+		}
+		return seq, nil
+	}
+
+	return 0, nil
+}
+
+func errUnknownConsumer(consumer string) error {
+	return errors.New("unknown consumer " + strconv.Quote(consumer) + ", add it to Consumers in the agent config")
+}
+
+type AckResult struct {
+	Consumer string
+	Acked    uint64
+	Pruned   int
+	Buffered int
+}
+
+func handleAck(w http.ResponseWriter, r *http.Request) {
+	consumer := r.URL.Query().Get("consumer")
+	if len(consumer) == 0 {
+		http.Error(w, "missing consumer to acknowledge for", http.StatusBadRequest)
+		return
+	}
+
+	seq, err := strconv.ParseUint(r.URL.Query().Get("seq"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad seq: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := AckResult{Consumer: consumer}
+
+	var ok bool
+	result.Pruned, ok = Acknowledge(consumer, seq)
+	if !ok {
+		http.Error(w, errUnknownConsumer(consumer).Error(), http.StatusNotFound)
+		return
+	}
+
+	checksLock.Lock()
+	result.Acked = cursors[consumer]
+	result.Buffered = len(checks)
+	checksLock.Unlock()
+
+	jsn, _ := json.Marshal(result)
+	fmt.Fprintf(w, "%s", jsn)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"worker"
+)
+
+func TestAcknowledge(t *testing.T) {
+	savedChecks, savedCursors := checks, cursors
+	defer func() { checks, cursors = savedChecks, savedCursors }()
+
+	checks = nil
+	cursors = make(map[string]uint64)
+	RegisterConsumers([]string{"primary", "dr"})
+
+	first := RecordCheck(worker.Check{ConfigLabel: "x"}).Seq
+	RecordCheck(worker.Check{ConfigLabel: "x"})
+	RecordCheck(worker.Check{ConfigLabel: "x"})
+
+	if pruned, ok := Acknowledge("primary", first + 1); !ok || pruned != 0 {
+		t.Errorf("primary ack = %d, %v, want nothing pruned until dr has acked too", pruned, ok)
+	}
+	if pruned, ok := Acknowledge("dr", first + 1); !ok || pruned != 2 {
+		t.Errorf("dr ack = %d, %v, want the 2 results both have acked pruned", pruned, ok)
+	}
+
+	if _, ok := Acknowledge("adhoc", first + 2); ok {
+		t.Error("an unregistered consumer's ack was accepted")
+	}
+	if _, ok := cursors["adhoc"]; ok {
+		t.Error("an unregistered consumer's ack created a cursor")
+	}
+	if len(checks) != 1 {
+		t.Errorf("%d results buffered, want 1", len(checks))
+	}
+}
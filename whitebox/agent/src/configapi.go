@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"logger"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"validation"
+)
+
+// Files written for a scraper syncing check bundles start with this, so it
+// knows which definitions it owns and can remove the ones it no longer
+// wants.
+const ManagedPrefix = "heimdall-managed-"
+
+// ConfigEntry is a check definition as the config API shows it.  Checksum is
+// the hex SHA256 of its file in config.d, which a scraper compares against
+// its own copy to spot drift.
+type ConfigEntry struct {
+	Config
+	Managed  bool
+	Checksum string
+}
+
+// configWriteLock keeps API writes to config.d from racing each other.
+var configWriteLock sync.Mutex
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// configFileFor picks a file name for a new definition with label.
+func configFileFor(label string, managed bool) string {
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(strings.ToLower(label), "_"), "_.")
+	if managed {
+		name = ManagedPrefix + name
+	}
+
+	return name + ".yml"
+}
+
+func checksumFile(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func findConfig(label string) (Config, bool) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	for _, c := range configs {
+		if c.Label == label {
+			return c, true
+		}
+	}
+
+	return Config{}, false
+}
+
+func configError(w http.ResponseWriter, status int, errs []validation.Error) {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, e.String())
+	}
+
+	http.Error(w, strings.Join(msgs, "\n"), status)
+}
+
+// handleConfig lists and changes the check definitions in config.d.
+//
+//	GET    /config[?label=X]       list definitions, or just X
+//	POST   /config[?managed=true]  add the definition in the body (YAML or JSON)
+//	PUT    /config[?managed=true]  add or replace the definition in the body
+//	DELETE /config?label=X         remove X
+//
+// Changes are written to config.d and applied straight away.  managed=true
+// marks a new definition as owned by a scraper's bundle sync.
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	label := r.URL.Query().Get("label")
+
+	switch r.Method {
+	case http.MethodGet:
+		entries := []ConfigEntry{}
+
+		reloadLock.Lock()
+		for _, c := range configs {
+			if label != "" && c.Label != label {
+				continue
+			}
+			entries = append(entries, ConfigEntry{Config: c, Managed: strings.HasPrefix(c.File, ManagedPrefix)})
+		}
+		reloadLock.Unlock()
+
+		for i := range entries {
+			entries[i].Checksum = checksumFile(filepath.Join(configDir, entries[i].File))
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Label < entries[j].Label })
+
+		if label != "" && len(entries) == 0 {
+			http.Error(w, "no check with label: " + label, http.StatusNotFound)
+			return
+		}
+
+		jsn, _ := json.Marshal(entries)
+		fmt.Fprintf(w, "%s", jsn)
+
+	case http.MethodPost, http.MethodPut:
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "couldn't read body: " + err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c, errs := ValidateConfig("request", body)
+		if len(errs) > 0 {
+			configError(w, http.StatusBadRequest, errs)
+			return
+		}
+
+		configWriteLock.Lock()
+		defer configWriteLock.Unlock()
+
+		file := ""
+		existing, found := findConfig(c.Label)
+		if found {
+			if r.Method == http.MethodPost {
+				http.Error(w, "check already exists: " + c.Label, http.StatusConflict)
+				return
+			}
+			file = existing.File
+		} else {
+			file = configFileFor(c.Label, r.URL.Query().Get("managed") == "true")
+			if _, err := os.Stat(filepath.Join(configDir, file)); err == nil {
+				http.Error(w, "config file already exists: " + file, http.StatusConflict)
+				return
+			}
+		}
+
+		err = ioutil.WriteFile(filepath.Join(configDir, file), body, 0644)
+		if err != nil {
+			http.Error(w, "couldn't write " + file + ": " + err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Config API Wrote " + file, logger.Fields{"label": c.Label})
+
+		result, err := ReloadConfigs("api")
+		if err != nil {
+			http.Error(w, "couldn't reload " + configDir + ": " + err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsn, _ := json.Marshal(result)
+		fmt.Fprintf(w, "%s", jsn)
+
+	case http.MethodDelete:
+		if label == "" {
+			http.Error(w, "missing label to delete", http.StatusBadRequest)
+			return
+		}
+
+		configWriteLock.Lock()
+		defer configWriteLock.Unlock()
+
+		existing, found := findConfig(label)
+		if !found {
+			http.Error(w, "no check with label: " + label, http.StatusNotFound)
+			return
+		}
+
+		err := os.Remove(filepath.Join(configDir, existing.File))
+		if err != nil {
+			http.Error(w, "couldn't remove " + existing.File + ": " + err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("Config API Removed " + existing.File, logger.Fields{"label": label})
+
+		result, err := ReloadConfigs("api")
+		if err != nil {
+			http.Error(w, "couldn't reload " + configDir + ": " + err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		jsn, _ := json.Marshal(result)
+		fmt.Fprintf(w, "%s", jsn)
+	}
+}
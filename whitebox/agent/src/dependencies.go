@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"worker"
+)
+
+// Dependency is one check's place in the dependency graph.  Missing lists
+// DependsOn labels that aren't configured and enabled.
+type Dependency struct {
+	Label      string
+	DependsOn  []string
+	Dependents []string
+	Missing    []string
+	LastState  string
+	Skipped    bool
+}
+
+type DependencyGraph struct {
+	Checks []Dependency
+	Cycles [][]string
+}
+
+// dependencyCycles returns every cycle in deps, each as the labels around
+// it starting from the one reached first.
+func dependencyCycles(deps map[string][]string) [][]string {
+	var cycles [][]string
+
+	labels := make([]string, 0, len(deps))
+	for label := range deps {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var stack []string
+
+	var visit func(label string)
+	visit = func(label string) {
+		state[label] = visiting
+		stack = append(stack, label)
+
+		for _, parent := range deps[label] {
+			switch state[parent] {
+			case unvisited:
+				if _, ok := deps[parent]; ok {
+					visit(parent)
+				}
+			case visiting:
+				for i := len(stack) - 1; i >= 0; i-- {
+					if stack[i] == parent {
+						cycles = append(cycles, append([]string(nil), stack[i:]...))
+						break
+					}
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[label] = done
+	}
+
+	for _, label := range labels {
+		if state[label] == unvisited {
+			visit(label)
+		}
+	}
+
+	return cycles
+}
+
+// FailedDependency returns the parent of c whose last run wasn't OK, and
+// its state.  A parent that was itself skipped is looked through to its own
+// parents, so a failure is only blamed on the check that actually failed and
+// a cycle can't keep every check in it skipped forever.
+func FailedDependency(c *Config) (string, string) {
+	if len(c.DependsOn) == 0 {
+		return "", ""
+	}
+
+	deps := make(map[string][]string)
+	reloadLock.Lock()
+	for label, rc := range running {
+		deps[label] = rc.Config.DependsOn
+	}
+	reloadLock.Unlock()
+	deps[c.Label] = c.DependsOn
+
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	visited := map[string]bool{c.Label: true}
+
+	var walk func(label string) (string, string)
+	walk = func(label string) (string, string) {
+		for _, parent := range deps[label] {
+			if visited[parent] {
+				continue
+			}
+			visited[parent] = true
+
+			st, ok := statuses[parent]
+			if !ok || st.LastState == "" {
+				continue
+			}
+
+			if st.Skipped {
+				if p, state := walk(parent); p != "" {
+					return p, state
+				}
+			} else if st.LastState != worker.StateOK {
+				return parent, st.LastState
+			}
+		}
+
+		return "", ""
+	}
+
+	return walk(c.Label)
+}
+
+func handleDependencies(w http.ResponseWriter, r *http.Request) {
+	graph := DependencyGraph{Checks: []Dependency{}, Cycles: [][]string{}}
+
+	deps := make(map[string][]string)
+	reloadLock.Lock()
+	for label, rc := range running {
+		deps[label] = rc.Config.DependsOn
+	}
+	reloadLock.Unlock()
+
+	dependents := make(map[string][]string)
+	for label, parents := range deps {
+		for _, parent := range parents {
+			dependents[parent] = append(dependents[parent], label)
+		}
+	}
+
+	statusLock.Lock()
+	for label, parents := range deps {
+		d := Dependency{Label: label, DependsOn: parents, Dependents: dependents[label]}
+		sort.Strings(d.Dependents)
+
+		for _, parent := range parents {
+			if _, ok := deps[parent]; !ok {
+				d.Missing = append(d.Missing, parent)
+			}
+		}
+
+		if st, ok := statuses[label]; ok {
+			d.LastState = st.LastState
+			d.Skipped = st.Skipped
+		}
+
+		graph.Checks = append(graph.Checks, d)
+	}
+	statusLock.Unlock()
+
+	sort.Slice(graph.Checks, func(i, j int) bool { return graph.Checks[i].Label < graph.Checks[j].Label })
+
+	if cycles := dependencyCycles(deps); cycles != nil {
+		graph.Cycles = cycles
+	}
+
+	jsn, _ := json.Marshal(graph)
+	fmt.Fprintf(w, "%s", jsn)
+}
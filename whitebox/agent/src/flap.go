@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync"
+	"time"
+	"worker"
+)
+
+// How many results flap detection looks back over, as Nagios does.
+const flapHistory = 21
+
+// Nagios' default service flap thresholds, in percent state change.  A check
+// starts flapping at FlapHigh and stops once it drops below FlapLow.
+const (
+	DefaultFlapLow  = 5.0
+	DefaultFlapHigh = 20.0
+)
+
+// Results in ReportMode "change" are still buffered this often (seconds)
+// when nothing changed, so consumers can tell the check is alive.
+const DefaultHeartbeat = 600
+
+const (
+	ReportAlways = "always"
+	ReportChange = "change"
+)
+
+type stateHistory struct {
+	label    string
+	states   []string
+	flapping bool
+
+	lastState    string
+	lastFlapping bool
+	lastReported time.Time
+}
+
+var histories = make(map[string]*stateHistory)
+var historyLock sync.Mutex
+
+// percentStateChange weights the transitions in states (oldest first) from
+// 0.75 for the oldest to 1.25 for the newest, like Nagios, so recent changes
+// count for more.
+func percentStateChange(states []string) float64 {
+	changes := 0.0
+	for x := 1; x < len(states); x++ {
+		if states[x] != states[x-1] {
+			changes += float64(x-1) * 0.5 / float64(flapHistory - 2) + 0.75
+		}
+	}
+
+	return changes * 100.0 / float64(flapHistory - 1)
+}
+
+// TrackState adds check to the history of its label and param, setting
+// Flapping and PercentStateChange.  It returns whether the result should be
+// buffered: always, unless c's ReportMode is "change" and neither the state
+// nor flapping changed since the last buffered result and the Heartbeat
+// hasn't passed.  While a check is flapping only the start and end of the
+// flapping are reported.
+func TrackState(c *Config, check *worker.Check) bool {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	key := latestKey(*check)
+	h, ok := histories[key]
+	if !ok {
+		h = &stateHistory{label: check.ConfigLabel}
+		histories[key] = h
+	}
+
+	h.states = append(h.states, check.State)
+	if len(h.states) > flapHistory {
+		h.states = h.states[len(h.states)-flapHistory:]
+	}
+
+	low, high := c.FlapLow, c.FlapHigh
+	if low <= 0 {
+		low = DefaultFlapLow
+	}
+	if high <= 0 {
+		high = DefaultFlapHigh
+	}
+
+	pct := percentStateChange(h.states)
+	if !h.flapping && pct >= high {
+		h.flapping = true
+	} else if h.flapping && pct < low {
+		h.flapping = false
+	}
+
+	check.Flapping = h.flapping
+	check.PercentStateChange = pct
+
+	report := true
+	if c.ReportMode == ReportChange && !h.lastReported.IsZero() {
+		heartbeat := c.Heartbeat
+		if heartbeat < 1 {
+			heartbeat = DefaultHeartbeat
+		}
+
+		changed := h.flapping != h.lastFlapping || (!h.flapping && check.State != h.lastState)
+		report = changed || time.Since(h.lastReported) >= time.Duration(heartbeat) * time.Second
+	}
+
+	if report {
+		h.lastState = check.State
+		h.lastFlapping = h.flapping
+		h.lastReported = time.Now()
+	}
+
+	return report
+}
+
+// ForgetHistory drops the state history of label's results.
+func ForgetHistory(label string) {
+	historyLock.Lock()
+	defer historyLock.Unlock()
+
+	for key, h := range histories {
+		if h.label == label {
+			delete(histories, key)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"worker"
+)
+
+// history returns flapHistory states, all OK except for CRITICAL at the given
+// positions (0 is the oldest).
+func history(critical ...int) []string {
+	states := make([]string, flapHistory)
+	for i := range states {
+		states[i] = worker.StateOK
+	}
+	for _, i := range critical {
+		states[i] = worker.StateCritical
+	}
+
+	return states
+}
+
+func TestPercentStateChange(t *testing.T) {
+	alternating := make([]string, flapHistory)
+	for i := range alternating {
+		alternating[i] = worker.StateOK
+		if i%2 == 1 {
+			alternating[i] = worker.StateCritical
+		}
+	}
+
+	tests := []struct {
+		name   string
+		states []string
+		want   float64
+	}{
+		{"no history", nil, 0},
+		{"steady", history(), 0},
+		// The oldest transition is weighted 0.75, out of 20 transitions
+		{"oldest transition", history(0), 0.75 * 100 / 20},
+		// and the newest 1.25
+		{"newest transition", history(flapHistory - 1), 1.25 * 100 / 20},
+		{"changes every time", alternating, 100},
+		// A blip is two transitions, the 10th and 11th of the 20
+		{"blip in the middle", history(10), (0.75 + 9*0.5/19 + 0.75 + 10*0.5/19) * 100 / 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := percentStateChange(tt.states)
+			if math.Abs(got - tt.want) > 1e-9 {
+				t.Errorf("percentStateChange = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackStateFlapping(t *testing.T) {
+	defer ForgetHistory("flappy")
+
+	c := &Config{Label: "flappy"}
+	run := func(state string) worker.Check {
+		check := worker.Check{ConfigLabel: "flappy", State: state}
+		TrackState(c, &check)
+		return check
+	}
+
+	for i := 0; i < flapHistory; i++ {
+		if check := run(worker.StateOK); check.Flapping {
+			t.Fatalf("steady check flapping after %d results", i+1)
+		}
+	}
+
+	// Alternate until it's over FlapHigh
+	state := worker.StateOK
+	flapped := false
+	for i := 0; i < flapHistory && !flapped; i++ {
+		if state == worker.StateOK {
+			state = worker.StateCritical
+		} else {
+			state = worker.StateOK
+		}
+
+		check := run(state)
+		flapped = check.Flapping
+		if flapped && check.PercentStateChange < DefaultFlapHigh {
+			t.Fatalf("started flapping at %v%%, below FlapHigh", check.PercentStateChange)
+		}
+	}
+	if !flapped {
+		t.Fatal("alternating check never started flapping")
+	}
+
+	// It stays flapping until it settles below FlapLow
+	stopped := false
+	for i := 0; i < flapHistory && !stopped; i++ {
+		check := run(worker.StateOK)
+		stopped = !check.Flapping
+		if stopped && check.PercentStateChange >= DefaultFlapLow {
+			t.Fatalf("stopped flapping at %v%%, not below FlapLow", check.PercentStateChange)
+		}
+	}
+	if !stopped {
+		t.Fatal("settled check never stopped flapping")
+	}
+}
+
+func TestTrackStateReportChange(t *testing.T) {
+	defer ForgetHistory("quiet")
+
+	c := &Config{Label: "quiet", ReportMode: ReportChange}
+	run := func(state string) bool {
+		check := worker.Check{ConfigLabel: "quiet", State: state}
+		return TrackState(c, &check)
+	}
+
+	steps := []struct {
+		state string
+		want  bool
+	}{
+		{worker.StateOK, true},
+		{worker.StateOK, false},
+		{worker.StateOK, false},
+		{worker.StateWarning, true},
+		{worker.StateWarning, false},
+		{worker.StateOK, true},
+	}
+
+	for i, step := range steps {
+		if got := run(step.state); got != step.want {
+			t.Errorf("result %d (%s): report = %v, want %v", i+1, step.state, got, step.want)
+		}
+	}
+}
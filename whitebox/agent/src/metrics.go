@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"worker"
+)
+
+// Units that are appended to the metric name, following the Prometheus
+// naming conventions.  Anything else (messages, inodes, files) is a plain
+// count and is left off.
+var metricUnitSuffixes = map[string]string{
+	"bytes":   "_bytes",
+	"seconds": "_seconds",
+	"percent": "_percent",
+	"days":    "_days",
+}
+
+var stateValues = map[string]int{
+	worker.StateOK:       0,
+	worker.StateWarning:  1,
+	worker.StateCritical: 2,
+	worker.StateUnknown:  3,
+}
+
+func metricName(m worker.Metric) string {
+	name := "heimdall_" + sanitizeMetricName(m.Name)
+	suffix := metricUnitSuffixes[m.Unit]
+	if !strings.HasSuffix(name, suffix) {
+		name += suffix
+	}
+
+	return name
+}
+
+func sanitizeMetricName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || (c >= '0' && c <= '9' && i > 0) {
+			continue
+		}
+		b[i] = '_'
+	}
+
+	return string(b)
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.Replace(value, "\\", "\\\\", -1)
+	value = strings.Replace(value, "\"", "\\\"", -1)
+	value = strings.Replace(value, "\n", "\\n", -1)
+	return value
+}
+
+func formatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, sanitizeMetricName(k)+"=\""+escapeLabelValue(labels[k])+"\"")
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// checkLabels are the labels every sample from a check carries.  Tags can't
+// replace label, host or param.
+func checkLabels(check worker.Check) map[string]string {
+	labels := map[string]string{
+		"label": check.ConfigLabel,
+		"host":  check.Host,
+	}
+	if check.Param != "" {
+		labels["param"] = check.Param
+	}
+	for k, v := range check.Tags {
+		if _, ok := labels[k]; !ok {
+			labels[k] = v
+		}
+	}
+
+	return labels
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	samples := make(map[string][]string)
+
+	checksLock.Lock()
+	for _, check := range latest {
+		labels := checkLabels(check)
+		if state, ok := stateValues[check.State]; ok {
+			samples["heimdall_check_state"] = append(samples["heimdall_check_state"], formatLabels(labels)+" "+strconv.Itoa(state))
+		}
+		samples["heimdall_check_timestamp_seconds"] = append(samples["heimdall_check_timestamp_seconds"], formatLabels(labels)+" "+strconv.FormatInt(check.EpochTime, 10))
+
+		flapping := "0"
+		if check.Flapping {
+			flapping = "1"
+		}
+		samples["heimdall_check_flapping"] = append(samples["heimdall_check_flapping"], formatLabels(labels)+" "+flapping)
+
+		for _, m := range check.Metrics {
+			mlabels := checkLabels(check)
+			for k, v := range m.Labels {
+				mlabels[k] = v
+			}
+
+			name := metricName(m)
+			samples[name] = append(samples[name], formatLabels(mlabels)+" "+strconv.FormatFloat(m.Value, 'g', -1, 64))
+		}
+	}
+	checksLock.Unlock()
+
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, name := range names {
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		sort.Strings(samples[name])
+		for _, sample := range samples[name] {
+			fmt.Fprintf(w, "%s%s\n", name, sample)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"worker"
+)
+
+// NextPageHeader is set on a /checks or /checkandclear response cut short by
+// ?limit=.  Its value is the continuation token: pass it back as
+// ?continue=<token> to /checks for the next page.  /checkandclear has
+// already removed what it returned, so just call it again.
+const NextPageHeader = "X-Heimdall-Next"
+
+// parseLimit reads ?limit=, the most results to return.  0 means all of them.
+func parseLimit(r *http.Request) (int, error) {
+	limit := r.URL.Query().Get("limit")
+	if limit == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(limit)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, errors.New("limit can't be negative")
+	}
+
+	return n, nil
+}
+
+// page cuts list down to limit results, returning the continuation token if
+// anything was left out.
+func page(list []worker.Check, limit int) ([]worker.Check, string) {
+	if limit < 1 || len(list) <= limit {
+		return list, ""
+	}
+
+	list = list[:limit]
+	return list, strconv.FormatUint(list[limit-1].Seq, 10)
+}
+
+// writeChecks streams list out as a JSON array one result at a time, rather
+// than building the whole thing in memory, gzipped if the client accepts it.
+func writeChecks(w http.ResponseWriter, r *http.Request, list []worker.Check, next string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if next != "" {
+		w.Header().Set(NextPageHeader, next)
+	}
+
+	var out io.Writer = w
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+
+	bw := bufio.NewWriter(out)
+	defer bw.Flush()
+
+	bw.WriteString("[")
+	for i, chk := range list {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		jsn, _ := json.Marshal(chk)
+		bw.Write(jsn)
+	}
+	bw.WriteString("]")
+}
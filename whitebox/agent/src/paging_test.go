@@ -0,0 +1,122 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"worker"
+)
+
+func seqs(from uint64, to uint64) []worker.Check {
+	var list []worker.Check
+	for seq := from; seq <= to; seq++ {
+		list = append(list, worker.Check{ConfigLabel: "x", Seq: seq})
+	}
+
+	return list
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"limit=", 0, false},
+		{"limit=0", 0, false},
+		{"limit=25", 25, false},
+		{"limit=-1", 0, true},
+		{"limit=ten", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := parseLimit(httptest.NewRequest("GET", "/checks?" + tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLimit(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseLimit(%q) = %d, want %d", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPage(t *testing.T) {
+	tests := []struct {
+		name     string
+		list     []worker.Check
+		limit    int
+		wantLen  int
+		wantNext string
+	}{
+		{"no limit", seqs(1, 10), 0, 10, ""},
+		{"empty", nil, 5, 0, ""},
+		{"under the limit", seqs(1, 3), 5, 3, ""},
+		{"exactly the limit", seqs(1, 5), 5, 5, ""},
+		{"over the limit", seqs(1, 10), 4, 4, "4"},
+		{"token is the last seq returned", seqs(7, 20), 3, 3, "9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next := page(tt.list, tt.limit)
+			if len(got) != tt.wantLen || next != tt.wantNext {
+				t.Errorf("page(%d results, %d) = %d results, next %q, want %d, next %q", len(tt.list), tt.limit, len(got), next, tt.wantLen, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestWriteChecks(t *testing.T) {
+	list := seqs(1, 3)
+
+	for _, gz := range []bool{false, true} {
+		req := httptest.NewRequest("GET", "/checks", nil)
+		if gz {
+			req.Header.Set("Accept-Encoding", "gzip")
+		}
+
+		w := httptest.NewRecorder()
+		writeChecks(w, req, list, "3")
+
+		if w.Header().Get(NextPageHeader) != "3" {
+			t.Errorf("gzip %v: %s = %q, want 3", gz, NextPageHeader, w.Header().Get(NextPageHeader))
+		}
+
+		body := w.Body.Bytes()
+		if gz {
+			if w.Header().Get("Content-Encoding") != "gzip" {
+				t.Fatal("gzip accepted but the response isn't gzipped")
+			}
+
+			r, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err = ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var got []worker.Check
+		err := json.Unmarshal(body, &got)
+		if err != nil {
+			t.Fatalf("gzip %v: bad JSON %q: %v", gz, body, err)
+		}
+		if len(got) != len(list) || got[2].Seq != 3 {
+			t.Errorf("gzip %v: got %+v, want %+v", gz, got, list)
+		}
+	}
+
+	// An empty page is still a JSON array
+	w := httptest.NewRecorder()
+	writeChecks(w, httptest.NewRequest("GET", "/checks", nil), nil, "")
+	if w.Body.String() != "[]" {
+		t.Errorf("empty page = %q, want []", w.Body.String())
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"logger"
+	"net/http"
+	"os"
+	"signing"
+	"strconv"
+	"sync"
+	"time"
+	"tlsutil"
+	"worker"
+)
+
+// The consumer name push mode acknowledges results under.
+const pushConsumer = "push"
+
+// PushStatus is how push mode is getting on, shown in /status.  Rejected
+// means the scraper last refused this host, e.g. it isn't marked Push there
+// or the credentials don't match, and stays set until a push gets through.
+type PushStatus struct {
+	URL       string
+	LastPush  int64
+	LastError string
+	Rejected  bool
+}
+
+var pushStatus *PushStatus
+var pushLock sync.Mutex
+
+// rejectedError is returned when the scraper refuses this host, which won't
+// be fixed until someone changes a config.
+type rejectedError struct {
+	msg string
+}
+
+func (e rejectedError) Error() string {
+	return e.msg
+}
+
+func setPushStatus(update func(p *PushStatus)) {
+	pushLock.Lock()
+	defer pushLock.Unlock()
+
+	update(pushStatus)
+}
+
+func getPushStatus() *PushStatus {
+	pushLock.Lock()
+	defer pushLock.Unlock()
+
+	if pushStatus == nil {
+		return nil
+	}
+
+	ps := *pushStatus
+	return &ps
+}
+
+// nextBatch returns up to size results the scraper hasn't been sent yet.
+func nextBatch(size int) []worker.Check {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+
+	batch := checksSince(cursors[pushConsumer])
+	if len(batch) > size {
+		batch = batch[:size]
+	}
+
+	return batch
+}
+
+func postChecks(client *http.Client, p PushConfig, hostname string, batch []worker.Check) error {
+	jsn, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", p.URL, bytes.NewReader(jsn))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signing.Authorize(req, p.Token, hostname, p.Secret, jsn)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return rejectedError{resp.Status + ": " + string(body)}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(resp.Status + ": " + string(body))
+	}
+
+	return nil
+}
+
+// Do_Push delivers the checks buffer to the scraper in batches, as the
+// "push" consumer.  A failed delivery is retried with exponential backoff,
+// up to MaxBackoff seconds between attempts, and nothing is acknowledged
+// until the scraper has accepted it.  If the scraper refuses this host,
+// e.g. while its config is being fixed, that's logged as an error and
+// retried every MaxBackoff seconds, so nothing is lost in the meantime.
+func Do_Push(p PushConfig) {
+	if p.Interval < 1 {
+		p.Interval = 60
+	}
+
+	if p.BatchSize < 1 {
+		p.BatchSize = 500
+	}
+
+	if p.MaxBackoff < 1 {
+		p.MaxBackoff = 300
+	}
+
+	tlsconfig, err := tlsutil.ClientConfig(p.CAFile, p.CertFile, p.KeyFile, p.ServerName, p.InsecureSkipVerify)
+	if err != nil {
+		fmt.Println("Push Mode Disabled, Bad TLS Settings: " + err.Error())
+		logger.Error("Push Mode Disabled, Bad TLS Settings: " + err.Error())
+		return
+	}
+
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsconfig},
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		fmt.Println("Push Mode Disabled, Can't Get The Hostname: " + err.Error())
+		logger.Error("Push Mode Disabled, Can't Get The Hostname: " + err.Error())
+		return
+	}
+
+	RegisterConsumers([]string{pushConsumer})
+
+	pushLock.Lock()
+	pushStatus = &PushStatus{URL: p.URL}
+	pushLock.Unlock()
+
+	interval := time.Duration(p.Interval) * time.Second
+	maxbackoff := time.Duration(p.MaxBackoff) * time.Second
+	backoff := time.Duration(0)
+	wait := interval
+
+	for {
+		time.Sleep(wait)
+
+		batch := nextBatch(p.BatchSize)
+		if len(batch) == 0 {
+			wait = interval
+			continue
+		}
+
+		err := postChecks(client, p, hostname, batch)
+		if err != nil {
+			setPushStatus(func(ps *PushStatus) { ps.LastError = err.Error() })
+
+			if _, ok := err.(rejectedError); ok {
+				setPushStatus(func(ps *PushStatus) { ps.Rejected = true })
+				logger.Error(p.URL + " Rejected This Host, Retrying In " + maxbackoff.String() + ": " + err.Error())
+
+				backoff = maxbackoff
+				wait = backoff
+				continue
+			}
+
+			if backoff == 0 {
+				backoff = time.Second
+			} else {
+				backoff *= 2
+			}
+			if backoff > maxbackoff {
+				backoff = maxbackoff
+			}
+
+			wait = backoff
+			logger.Warn("Failed To Push " + strconv.Itoa(len(batch)) + " Checks To " + p.URL + ", Retrying In " + backoff.String() + ": " + err.Error())
+			continue
+		}
+
+		backoff = 0
+		Acknowledge(pushConsumer, batch[len(batch)-1].Seq)
+		setPushStatus(func(ps *PushStatus) {
+			ps.LastPush = time.Now().Unix()
+			ps.LastError = ""
+			ps.Rejected = false
+		})
+
+		// Keep going straight away if there's a backlog to clear.
+		if len(nextBatch(1)) > 0 {
+			wait = 0
+		} else {
+			wait = interval
+		}
+	}
+}
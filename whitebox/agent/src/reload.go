@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"logger"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How often config.d is polled for changes.
+const configWatchInterval = 10 * time.Second
+
+type runningCheck struct {
+	Config Config
+	Stop   chan struct{}
+}
+
+type ReloadResult struct {
+	Trigger   string
+	Time      string
+	EpochTime int64
+	Added     []string
+	Removed   []string
+	Changed   []string
+	Unchanged []string
+	Errors    []string
+}
+
+var running = make(map[string]*runningCheck)
+var lastReload ReloadResult
+var reloadLock sync.Mutex
+
+func startCheck(c Config) *runningCheck {
+	rc := &runningCheck{Config: c, Stop: make(chan struct{})}
+	cfg := c
+	go Do_Checks(&cfg, chanl, rc.Stop)
+	return rc
+}
+
+func newReloadResult(trigger string) ReloadResult {
+	now := time.Now()
+	return ReloadResult{
+		Trigger:   trigger,
+		Time:      now.Local().Format("Jan 02 2006 03:04:05"),
+		EpochTime: now.Unix(),
+	}
+}
+
+// ApplyConfigs diffs newconfigs against the running checks by Label, starting
+// new ones, stopping removed or disabled ones, and restarting any whose
+// config changed.  Results already in the checks buffer are kept.  The
+// checks previously loaded from a file in failed are kept as they were, so a
+// typo or a half saved file doesn't turn them off.
+func ApplyConfigs(newconfigs []Config, failed map[string]error, trigger string) ReloadResult {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	result := newReloadResult(trigger)
+
+	for file, err := range failed {
+		result.Errors = append(result.Errors, file + ": " + err.Error())
+	}
+	for _, c := range configs {
+		if _, ok := failed[c.File]; ok {
+			newconfigs = append(newconfigs, c)
+		}
+	}
+
+	wanted := make(map[string]Config)
+	for _, c := range newconfigs {
+		if !c.Enabled {
+			continue
+		}
+
+		if _, ok := wanted[c.Label]; ok {
+			logger.Warn("Duplicate Check Label (" + c.Label + "), Using The Last One Loaded", logger.Fields{"label": c.Label})
+		}
+		wanted[c.Label] = c
+	}
+
+	for label, rc := range running {
+		if _, ok := wanted[label]; !ok {
+			close(rc.Stop)
+			delete(running, label)
+			ForgetStatus(label)
+			ForgetLatest(label)
+			ForgetHistory(label)
+			ForgetSubmissions(label)
+			result.Removed = append(result.Removed, label)
+		}
+	}
+
+	for label, c := range wanted {
+		rc, ok := running[label]
+		if !ok {
+			running[label] = startCheck(c)
+			result.Added = append(result.Added, label)
+		} else if !reflect.DeepEqual(rc.Config, c) {
+			close(rc.Stop)
+			ForgetLatest(label)
+			ForgetHistory(label)
+			running[label] = startCheck(c)
+			result.Changed = append(result.Changed, label)
+		} else {
+			result.Unchanged = append(result.Unchanged, label)
+		}
+	}
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+	sort.Strings(result.Changed)
+	sort.Strings(result.Unchanged)
+	sort.Strings(result.Errors)
+
+	configs = newconfigs
+	lastReload = result
+
+	msg := "Config Reload (" + trigger + "): added [" + strings.Join(result.Added, ", ") + "] removed [" + strings.Join(result.Removed, ", ") + "] changed [" + strings.Join(result.Changed, ", ") + "]"
+	fmt.Println(msg)
+	Log(msg)
+
+	if len(result.Errors) > 0 {
+		logger.Error("Config Reload (" + trigger + ") Kept The Previous Checks From Files With Errors: " + strings.Join(result.Errors, "; "))
+	}
+
+	return result
+}
+
+// ReloadConfigs reads config.d and applies it.  If config.d can't be read
+// the running checks are left alone, and the error is what /reload shows as
+// the last reload.
+func ReloadConfigs(trigger string) (ReloadResult, error) {
+	newconfigs, failed, err := GetConfigs()
+	if err != nil {
+		logger.Error("Config Reload (" + trigger + ") Skipped: " + err.Error())
+
+		result := newReloadResult(trigger)
+		result.Errors = []string{configDir + ": " + err.Error()}
+
+		reloadLock.Lock()
+		lastReload = result
+		reloadLock.Unlock()
+
+		return result, err
+	}
+
+	return ApplyConfigs(newconfigs, failed, trigger), nil
+}
+
+// configSignature summarises the names, sizes and modification times of
+// everything in config.d so changes can be detected without a reload.
+func configSignature() string {
+	files, err := ioutil.ReadDir(configDir)
+	if err != nil {
+		return ""
+	}
+
+	sig := ""
+	for _, f := range files {
+		sig += f.Name() + ":" + fmt.Sprint(f.Size()) + ":" + fmt.Sprint(f.ModTime().UnixNano()) + ";"
+	}
+
+	return sig
+}
+
+// WatchConfigs reloads on SIGHUP, or when anything in config.d changes.
+// While config.d can't be read, starting with failed, it's retried on every
+// poll.
+func WatchConfigs(failed bool) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	sig := configSignature()
+	for {
+		select {
+		case <-sighup:
+			sig = configSignature()
+			_, err := ReloadConfigs("SIGHUP")
+			failed = err != nil
+		case <-ticker.C:
+			newsig := configSignature()
+			if newsig != sig {
+				sig = newsig
+				_, err := ReloadConfigs("file change")
+				failed = err != nil
+			} else if failed {
+				_, err := ReloadConfigs("retry")
+				failed = err != nil
+			}
+		}
+	}
+}
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	var result ReloadResult
+
+	if r.Method == http.MethodPost {
+		var err error
+		result, err = ReloadConfigs("api")
+		if err != nil {
+			http.Error(w, "couldn't read " + configDir + ": " + err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		reloadLock.Lock()
+		result = lastReload
+		reloadLock.Unlock()
+	}
+
+	jsn, _ := json.Marshal(result)
+	fmt.Fprintf(w, "%s", jsn)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplyConfigsKeepsFailedFiles(t *testing.T) {
+	defer ApplyConfigs(nil, nil, "test")
+
+	// Passive checks with no Freshness just wait to be stopped
+	a := Config{Label: "a", CommandType: "passive", Enabled: true, File: "a.yml"}
+	b := Config{Label: "b", CommandType: "passive", Enabled: true, File: "b.yml"}
+
+	result := ApplyConfigs([]Config{a, b}, nil, "test")
+	if !reflect.DeepEqual(result.Added, []string{"a", "b"}) {
+		t.Fatalf("Added = %v, want [a b]", result.Added)
+	}
+
+	result = ApplyConfigs([]Config{a}, map[string]error{"b.yml": errors.New("bad yaml")}, "test")
+	if len(result.Removed) != 0 || !reflect.DeepEqual(result.Unchanged, []string{"a", "b"}) {
+		t.Errorf("b.yml failed to parse: Removed = %v, Unchanged = %v, want b kept", result.Removed, result.Unchanged)
+	}
+	if !reflect.DeepEqual(result.Errors, []string{"b.yml: bad yaml"}) {
+		t.Errorf("Errors = %v, want [b.yml: bad yaml]", result.Errors)
+	}
+
+	// Once it's gone for real it's stopped
+	result = ApplyConfigs([]Config{a}, nil, "test")
+	if !reflect.DeepEqual(result.Removed, []string{"b"}) {
+		t.Errorf("Removed = %v, want [b]", result.Removed)
+	}
+}
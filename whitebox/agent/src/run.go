@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"worker"
+)
+
+// handleRun runs a configured check straight away instead of waiting for
+// its next turn.  ?label=<Label> runs one check, ?label=all runs every
+// enabled check.  Every result is returned, and the run shows in /status
+// like a scheduled one.  Results go into the buffer just as a scheduled
+// run's would, so with ReportMode change an unchanged result isn't buffered.
+func handleRun(w http.ResponseWriter, r *http.Request) {
+	label := r.URL.Query().Get("label")
+	if len(label) == 0 {
+		http.Error(w, "missing label to run", http.StatusBadRequest)
+		return
+	}
+
+	var torun []Config
+	reloadLock.Lock()
+	for l, rc := range running {
+		if rc.Config.CommandType == "passive" {
+			continue
+		}
+		if label == "all" || l == label {
+			torun = append(torun, rc.Config)
+		}
+	}
+	reloadLock.Unlock()
+
+	if len(torun) == 0 {
+		http.Error(w, "no enabled check with label: " + label, http.StatusNotFound)
+		return
+	}
+
+	perconfig := make([][]worker.Check, len(torun))
+
+	var wg sync.WaitGroup
+	for i := range torun {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			c := &torun[i]
+			StartedRun(c.Label)
+			results := RunCheck(c)
+			FinishedRun(c.Label, results, NextRunOf(c.Label))
+
+			for _, check := range results {
+				if TrackState(c, &check) {
+					check = RecordCheck(check)
+				} else {
+					UpdateLatest(check)
+				}
+				perconfig[i] = append(perconfig[i], check)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	results := []worker.Check{}
+	for _, r := range perconfig {
+		results = append(results, r...)
+	}
+
+	jsn, _ := json.Marshal(results)
+	fmt.Fprintf(w, "%s", jsn)
+}
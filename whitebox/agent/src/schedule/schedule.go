@@ -0,0 +1,216 @@
+package schedule
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schedule says when a check should next run.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+var random = rand.New(rand.NewSource(time.Now().UnixNano()))
+var randomLock sync.Mutex
+
+// RandomDuration returns a random duration in [0, max).
+func RandomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	randomLock.Lock()
+	defer randomLock.Unlock()
+
+	return time.Duration(random.Int63n(int64(max)))
+}
+
+// Interval runs every Every, plus up to Jitter extra each time so hosts
+// drift apart instead of firing together.
+type Interval struct {
+	Every  time.Duration
+	Jitter time.Duration
+}
+
+func (i Interval) Next(after time.Time) time.Time {
+	return after.Add(i.Every + RandomDuration(i.Jitter))
+}
+
+// Cron runs at the times matched by a standard five field cron expression
+// (minute hour day-of-month month day-of-week), plus up to Jitter extra.
+type Cron struct {
+	Expr   string
+	Jitter time.Duration
+
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+
+	domStar bool
+	dowStar bool
+}
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type field struct {
+	name string
+	min  int
+	max  int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCron parses expr, which may also be one of @hourly, @daily, @weekly,
+// @monthly or @yearly.  Each field takes *, a value, a range a-b, a list
+// a,b,c and a step */n or a-b/n.  Day of week 7 is Sunday, same as 0.
+func ParseCron(expr string) (*Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if d, ok := descriptors[spec]; ok {
+		spec = d
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return nil, errors.New("cron expression needs 5 fields (minute hour day-of-month month day-of-week): " + expr)
+	}
+
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, errors.New("bad " + fields[i].name + " in cron expression \"" + expr + "\": " + err.Error())
+		}
+		bits[i] = b
+	}
+
+	// Sunday can be 0 or 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	c := &Cron{
+		Expr:    expr,
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: strings.HasPrefix(parts[2], "*"),
+		dowStar: strings.HasPrefix(parts[4], "*"),
+	}
+
+	return c, nil
+}
+
+func parseField(spec string, f field) (uint64, error) {
+	var bits uint64
+
+	for _, item := range strings.Split(spec, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			s, err := strconv.Atoi(item[i+1:])
+			if err != nil || s < 1 {
+				return 0, errors.New("bad step: " + item)
+			}
+			step = s
+			item = item[:i]
+		}
+
+		start, end := f.min, f.max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+
+			s, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, errors.New("bad value: " + item)
+			}
+			start, end = s, s
+
+			if len(bounds) == 2 {
+				e, err := strconv.Atoi(bounds[1])
+				if err != nil {
+					return 0, errors.New("bad value: " + item)
+				}
+				end = e
+			} else if step > 1 {
+				end = f.max
+			}
+		}
+
+		if start < f.min || end > f.max || start > end {
+			return 0, errors.New(item + " is out of range " + strconv.Itoa(f.min) + "-" + strconv.Itoa(f.max))
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dommatch := c.dom&(1<<uint(t.Day())) != 0
+	dowmatch := c.dow&(1<<uint(t.Weekday())) != 0
+
+	// As in cron(8), when both day fields are restricted either may match.
+	if c.domStar || c.dowStar {
+		return dommatch && dowmatch
+	}
+	return dommatch || dowmatch
+}
+
+// Next returns the first matching minute after after, in after's location,
+// or the zero Time if the expression never matches.
+func (c *Cron) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+
+	// Give up after a few years, the expression can't match (e.g. Feb 30)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t.Add(RandomDuration(c.Jitter))
+	}
+
+	return time.Time{}
+}
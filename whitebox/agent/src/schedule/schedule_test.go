@@ -0,0 +1,121 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func at(s string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04", s)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func TestParseCronErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"too few fields", "* * * *"},
+		{"too many fields", "* * * * * *"},
+		{"minute out of range", "60 * * * *"},
+		{"hour out of range", "0 24 * * *"},
+		{"day of month zero", "0 0 0 * *"},
+		{"month out of range", "0 0 * 13 *"},
+		{"day of week out of range", "0 0 * * 8"},
+		{"zero step", "*/0 * * * *"},
+		{"bad step", "*/x * * * *"},
+		{"backwards range", "5-1 * * * *"},
+		{"not a number", "a * * * *"},
+		{"unknown descriptor", "@fortnightly"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCron(tt.expr); err == nil {
+				t.Errorf("ParseCron(%q) succeeded, want an error", tt.expr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name  string
+		expr  string
+		after string
+		want  string
+	}{
+		{"every minute", "* * * * *", "2024-01-01 10:07", "2024-01-01 10:08"},
+		{"strictly after a match", "0 * * * *", "2024-01-01 10:00", "2024-01-01 11:00"},
+		{"step", "*/15 * * * *", "2024-01-01 10:07", "2024-01-01 10:15"},
+		{"step from a start", "5/20 * * * *", "2024-01-01 10:26", "2024-01-01 10:45"},
+		{"list", "5,10 * * * *", "2024-01-01 10:07", "2024-01-01 10:10"},
+		{"range with step", "0 9-17/4 * * *", "2024-01-01 10:07", "2024-01-01 13:00"},
+		{"range with step past its end", "0 9-17/4 * * *", "2024-01-01 17:01", "2024-01-02 09:00"},
+		{"next day", "30 2 * * *", "2024-01-01 03:00", "2024-01-02 02:30"},
+		{"month rollover", "0 0 1 * *", "2024-01-31 12:00", "2024-02-01 00:00"},
+		{"year rollover", "0 0 1 1 *", "2024-06-01 00:00", "2025-01-01 00:00"},
+		{"skips short months", "0 0 31 * *", "2024-04-01 00:00", "2024-05-31 00:00"},
+		{"leap day", "0 0 29 2 *", "2024-03-01 00:00", "2028-02-29 00:00"},
+		{"day of week", "0 0 * * 1", "2024-09-01 00:00", "2024-09-02 00:00"},
+		{"sunday as 7", "0 12 * * 7", "2024-09-02 00:00", "2024-09-08 12:00"},
+		{"day of week range", "0 8 * * 1-5", "2024-09-06 09:00", "2024-09-09 08:00"},
+		{"day of month or day of week", "0 0 13 * 5", "2024-09-01 00:00", "2024-09-06 00:00"},
+		{"day of month or day of week, month day first", "0 0 13 * 5", "2024-09-07 00:00", "2024-09-13 00:00"},
+		{"restricted day of month with starred day of week", "0 0 13 * *", "2024-09-01 00:00", "2024-09-13 00:00"},
+		{"hourly", "@hourly", "2024-01-01 10:07", "2024-01-01 11:00"},
+		{"weekly", "@weekly", "2024-09-02 00:00", "2024-09-08 00:00"},
+		{"never matches", "0 0 30 2 *", "2024-01-01 00:00", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+			}
+
+			got := c.Next(at(tt.after))
+
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("Next(%s) = %s, want never", tt.after, got)
+				}
+				return
+			}
+
+			if want := at(tt.want); !got.Equal(want) {
+				t.Errorf("%q Next(%s) = %s, want %s", tt.expr, tt.after, got, want)
+			}
+		})
+	}
+}
+
+func TestCronNextJitter(t *testing.T) {
+	c, err := ParseCron("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Jitter = time.Minute
+
+	base := at("2024-01-01 11:00")
+	for i := 0; i < 100; i++ {
+		got := c.Next(at("2024-01-01 10:07"))
+		if got.Before(base) || !got.Before(base.Add(time.Minute)) {
+			t.Fatalf("Next with 1m jitter = %s, want in [%s, %s)", got, base, base.Add(time.Minute))
+		}
+	}
+}
+
+func TestIntervalNext(t *testing.T) {
+	i := Interval{Every: time.Minute}
+	after := at("2024-01-01 10:07")
+
+	if got := i.Next(after); !got.Equal(after.Add(time.Minute)) {
+		t.Errorf("Next(%s) = %s, want %s", after, got, after.Add(time.Minute))
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"logger"
+	"net/http"
+	"schedule"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"worker"
+)
+
+// MaintenanceWindow silences Labels (or the whole host, if Labels is empty)
+// either once, from Start to End (RFC3339), or every time the cron
+// expression in Schedule fires, for Duration seconds.
+type MaintenanceWindow struct {
+	Name     string   `yaml:"Name"`
+	Labels   []string `yaml:"Labels"`
+	Start    string   `yaml:"Start"`
+	End      string   `yaml:"End"`
+	Schedule string   `yaml:"Schedule"`
+	Duration int      `yaml:"Duration"`
+}
+
+// Silence is an ad hoc silence made through the API.
+type Silence struct {
+	ID        int
+	Labels    []string
+	Reason    string
+	Start     int64
+	End       int64
+	CreatedBy string
+}
+
+var silences []Silence
+var nextSilenceID = 1
+var silenceLock sync.Mutex
+
+func coversLabel(labels []string, label string) bool {
+	if len(labels) == 0 {
+		return true
+	}
+
+	for _, l := range labels {
+		if l == label || l == "all" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Active reports whether the window covers t.
+func (mw MaintenanceWindow) Active(t time.Time) (bool, error) {
+	if mw.Schedule != "" {
+		cron, err := schedule.ParseCron(mw.Schedule)
+		if err != nil {
+			return false, err
+		}
+
+		// The first start after t-Duration is the only one that can still
+		// be running at t.
+		duration := time.Duration(mw.Duration) * time.Second
+		start := cron.Next(t.Add(-duration - time.Minute))
+		return !start.IsZero() && !start.After(t) && t.Before(start.Add(duration)), nil
+	}
+
+	if mw.Start == "" || mw.End == "" {
+		return false, errors.New("maintenance window " + mw.Name + " needs Start and End, or Schedule and Duration")
+	}
+
+	start, err := time.Parse(time.RFC3339, mw.Start)
+	if err != nil {
+		return false, err
+	}
+
+	end, err := time.Parse(time.RFC3339, mw.End)
+	if err != nil {
+		return false, err
+	}
+
+	return !t.Before(start) && t.Before(end), nil
+}
+
+// SilencedBy returns why label is silenced at t, or "" if it isn't.
+func SilencedBy(label string, t time.Time) string {
+	for _, mw := range agentconfig.Maintenance {
+		if !coversLabel(mw.Labels, label) {
+			continue
+		}
+
+		active, err := mw.Active(t)
+		if err != nil {
+			logger.Error("Bad Maintenance Window " + mw.Name + ": " + err.Error())
+			continue
+		}
+
+		if active {
+			return "maintenance window: " + mw.Name
+		}
+	}
+
+	silenceLock.Lock()
+	defer silenceLock.Unlock()
+
+	for _, s := range silences {
+		if t.Unix() >= s.Start && t.Unix() < s.End && coversLabel(s.Labels, label) {
+			return "silenced: " + s.Reason
+		}
+	}
+
+	return ""
+}
+
+// ApplySilences flags a check that's covered by a maintenance window or
+// silence.  The check still ran, it's up to the scraper and plugins not to
+// alert on it.
+func ApplySilences(check *worker.Check) {
+	reason := SilencedBy(check.ConfigLabel, time.Now())
+	if reason != "" {
+		check.Silenced = true
+		check.SilenceReason = reason
+	}
+}
+
+// expireSilences drops silences that have ended.  silenceLock must be held.
+func expireSilences() {
+	now := time.Now().Unix()
+
+	active := silences[:0]
+	for _, s := range silences {
+		if s.End > now {
+			active = append(active, s)
+		}
+	}
+	silences = active
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(value); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// handleSilence creates a silence with POST
+// ?label=<Label>|all&duration=<seconds or 1h30m>&reason=..., removes one
+// with DELETE ?id=<ID>, and lists them with GET.
+func handleSilence(w http.ResponseWriter, r *http.Request) {
+	silenceLock.Lock()
+	defer silenceLock.Unlock()
+
+	expireSilences()
+
+	switch r.Method {
+	case http.MethodPost:
+		labels := r.URL.Query()["label"]
+		if len(labels) == 0 {
+			http.Error(w, "missing label to silence, use label=all for the whole host", http.StatusBadRequest)
+			return
+		}
+
+		duration, err := parseDuration(r.URL.Query().Get("duration"))
+		if err != nil || duration <= 0 {
+			http.Error(w, "missing or bad duration", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		s := Silence{
+			ID:        nextSilenceID,
+			Labels:    labels,
+			Reason:    r.URL.Query().Get("reason"),
+			Start:     now.Unix(),
+			End:       now.Add(duration).Unix(),
+			CreatedBy: r.RemoteAddr,
+		}
+		if cred, ok := credentialOf(r); ok {
+			s.CreatedBy = cred.Name
+		}
+
+		nextSilenceID++
+		silences = append(silences, s)
+		Log("Silence " + strconv.Itoa(s.ID) + " Added By " + s.CreatedBy + " For " + duration.String() + ": " + s.Reason)
+
+		jsn, _ := json.Marshal(s)
+		fmt.Fprintf(w, "%s", jsn)
+
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "missing or bad id", http.StatusBadRequest)
+			return
+		}
+
+		for i, s := range silences {
+			if s.ID == id {
+				silences = append(silences[:i], silences[i+1:]...)
+				Log("Silence " + strconv.Itoa(id) + " Removed")
+				fmt.Fprintf(w, "removed silence %d", id)
+				return
+			}
+		}
+
+		http.Error(w, "no such silence", http.StatusNotFound)
+
+	default:
+		type listing struct {
+			Silences    []Silence
+			Maintenance []MaintenanceWindow
+		}
+
+		l := listing{Silences: append([]Silence{}, silences...)}
+		now := time.Now()
+		for _, mw := range agentconfig.Maintenance {
+			if active, err := mw.Active(now); err == nil && active {
+				l.Maintenance = append(l.Maintenance, mw)
+			}
+		}
+
+		sort.Slice(l.Silences, func(i, j int) bool { return l.Silences[i].ID < l.Silences[j].ID })
+
+		jsn, _ := json.Marshal(l)
+		fmt.Fprintf(w, "%s", jsn)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+	"worker"
+)
+
+var startTime = time.Now()
+
+// CheckStatus is the scheduling health of one configured check.  Overdue
+// means the check should have run by now but hasn't, which is the sign of a
+// wedged Do_Checks goroutine.
+type CheckStatus struct {
+	Label               string
+	Running             bool
+	LastRun             int64
+	LastDuration        float64
+	LastState           string
+	NextRun             int64
+	Overdue             bool
+	ConsecutiveFailures int
+	BufferDepth         int
+	Skipped             bool
+
+	started time.Time
+}
+
+type AgentStatus struct {
+	Version     string
+	StartTime   int64
+	Uptime      float64
+	ConfigFiles []string
+	Buffered    int
+	LastSeq     uint64
+	Push        *PushStatus
+	Checks      []CheckStatus
+}
+
+// How long past NextRun a check can be before it's reported Overdue.
+const overdueGrace = 30 * time.Second
+
+var statuses = make(map[string]*CheckStatus)
+var statusLock sync.Mutex
+
+func getStatus(label string) *CheckStatus {
+	st, ok := statuses[label]
+	if !ok {
+		st = &CheckStatus{Label: label}
+		statuses[label] = st
+	}
+
+	return st
+}
+
+func ScheduledRun(label string, next time.Time) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	getStatus(label).NextRun = next.Unix()
+}
+
+// NextRunOf returns when label is next scheduled to run, zero if it isn't.
+func NextRunOf(label string) time.Time {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	if st, ok := statuses[label]; ok && st.NextRun > 0 {
+		return time.Unix(st.NextRun, 0)
+	}
+
+	return time.Time{}
+}
+
+func StartedRun(label string) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	st := getStatus(label)
+	st.Running = true
+	st.started = time.Now()
+	st.LastRun = st.started.Unix()
+}
+
+// stateRank orders states from best to worst, for summing up a run that
+// gave several results.
+var stateRank = map[string]int{
+	worker.StateOK:       0,
+	worker.StateUnknown:  1,
+	worker.StateWarning:  2,
+	worker.StateCritical: 3,
+}
+
+// FinishedRun records a run of label.  LastState is the worst of its
+// results.  A run skipped for a failed dependency doesn't count towards
+// ConsecutiveFailures.
+func FinishedRun(label string, results []worker.Check, next time.Time) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	state := worker.StateOK
+	skipped := false
+	for _, check := range results {
+		if stateRank[check.State] > stateRank[state] {
+			state = check.State
+		}
+		skipped = skipped || check.Skipped
+	}
+
+	st := getStatus(label)
+	st.Running = false
+	st.LastDuration = time.Since(st.started).Seconds()
+	st.LastState = state
+	st.Skipped = skipped
+	st.NextRun = 0
+	if !next.IsZero() {
+		st.NextRun = next.Unix()
+	}
+
+	if skipped {
+		return
+	}
+
+	if state == worker.StateOK {
+		st.ConsecutiveFailures = 0
+	} else {
+		st.ConsecutiveFailures++
+	}
+}
+
+func ForgetStatus(label string) {
+	statusLock.Lock()
+	defer statusLock.Unlock()
+
+	delete(statuses, label)
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	status := AgentStatus{
+		Version:   Version,
+		StartTime: startTime.Unix(),
+		Uptime:    now.Sub(startTime).Seconds(),
+	}
+
+	reloadLock.Lock()
+	for _, c := range configs {
+		if c.File != "" {
+			status.ConfigFiles = append(status.ConfigFiles, c.File)
+		}
+	}
+	reloadLock.Unlock()
+	sort.Strings(status.ConfigFiles)
+
+	depth := make(map[string]int)
+	checksLock.Lock()
+	for _, chk := range checks {
+		depth[chk.ConfigLabel]++
+	}
+	status.Buffered = len(checks)
+	status.LastSeq = lastSeq
+	checksLock.Unlock()
+
+	status.Push = getPushStatus()
+
+	statusLock.Lock()
+	for _, st := range statuses {
+		cs := *st
+		cs.BufferDepth = depth[cs.Label]
+		cs.Overdue = cs.NextRun > 0 && now.After(time.Unix(cs.NextRun, 0).Add(overdueGrace))
+		status.Checks = append(status.Checks, cs)
+	}
+	statusLock.Unlock()
+
+	sort.Slice(status.Checks, func(i, j int) bool { return status.Checks[i].Label < status.Checks[j].Label })
+
+	jsn, _ := json.Marshal(status)
+	fmt.Fprintf(w, "%s", jsn)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"logger"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"worker"
+)
+
+// Biggest /submit body we'll read.
+const maxSubmitSize = 1024 * 1024
+
+// Submission is a result for a passive check, sent to /submit by a local
+// process.  State is OK, WARNING, CRITICAL or UNKNOWN; if it's left out,
+// Retval is read as a Nagios exit code instead.  EpochTime defaults to now.
+type Submission struct {
+	Label     string
+	Param     string
+	State     string
+	Retval    int
+	Output    string
+	Value     float64
+	Metrics   []worker.Metric
+	EpochTime int64
+}
+
+// SubmitResult is the reply to /submit.  Buffered holds the results that
+// went into the buffer, which is fewer than Accepted when a check's
+// ReportMode is "change" and nothing changed.
+type SubmitResult struct {
+	Accepted int
+	Buffered []worker.Check
+}
+
+// When each passive check last had a result submitted.
+var lastSubmit = make(map[string]time.Time)
+var submitLock sync.Mutex
+
+func lastSubmission(label string) time.Time {
+	submitLock.Lock()
+	defer submitLock.Unlock()
+
+	return lastSubmit[label]
+}
+
+// ForgetSubmissions drops when label last had a result submitted.
+func ForgetSubmissions(label string) {
+	submitLock.Lock()
+	defer submitLock.Unlock()
+
+	delete(lastSubmit, label)
+}
+
+// freshness is how long a passive check's results stay fresh, its
+// Freshness.  0, the default, means they never go stale.
+func freshness(c *Config) time.Duration {
+	return time.Duration(c.Freshness) * time.Second
+}
+
+var nagiosCodes = map[string]int{
+	worker.StateOK:       0,
+	worker.StateWarning:  1,
+	worker.StateCritical: 2,
+	worker.StateUnknown:  3,
+}
+
+// passiveConfig returns the enabled passive check with label.  Only labels
+// set up in config.d with CommandType "passive" can be submitted to.
+func passiveConfig(label string) (Config, bool) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	rc, ok := running[label]
+	if !ok || rc.Config.CommandType != "passive" {
+		return Config{}, false
+	}
+
+	return rc.Config, true
+}
+
+// parseSubmissions accepts a single Submission or a JSON array of them.
+func parseSubmissions(body []byte) ([]Submission, error) {
+	var subs []Submission
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(trimmed, &subs)
+		return subs, err
+	}
+
+	var sub Submission
+	err := json.Unmarshal(trimmed, &sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(subs, sub), nil
+}
+
+// submissionCheck turns sub into a result of c, the way RunCheck would for
+// an active check.
+func submissionCheck(c Config, sub Submission) (worker.Check, error) {
+	check := worker.Check{}
+
+	state := strings.ToUpper(sub.State)
+	if state == "" {
+		state = worker.NagiosState(sub.Retval)
+	} else if _, ok := nagiosCodes[state]; !ok {
+		return check, errors.New(sub.Label + ": unknown State " + strconv.Quote(sub.State))
+	}
+
+	when := time.Now()
+	if sub.EpochTime > 0 {
+		when = time.Unix(sub.EpochTime, 0)
+	}
+
+	check.ConfigLabel = c.Label
+	check.Param = sub.Param
+	check.TimeStamp = when.Local().Format("Jan 02 2006 03:04:05")
+	check.EpochTime = when.Unix()
+	check.Command = "passive"
+	check.Output = sub.Output
+	check.Retval = nagiosCodes[state]
+	check.State = state
+	check.Value = sub.Value
+	check.Metrics = sub.Metrics
+
+	EvaluateState(&c, sub.Param, &check)
+
+	hstname, err := os.Hostname()
+	if err != nil {
+		hstname = "Error Getting Hostname: " + err.Error()
+	}
+	check.Host = hstname
+	check.Tags = c.ResultTags()
+
+	return check, nil
+}
+
+// handleSubmit takes results for passive checks from local processes, e.g.
+// batch jobs and cron scripts, and buffers them like any other result.  The
+// body is one Submission or an array of them, and nothing is recorded
+// unless every one is valid.
+func handleSubmit(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSubmitSize))
+	if err != nil {
+		http.Error(w, "couldn't read body: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subs, err := parseSubmissions(body)
+	if err != nil {
+		http.Error(w, "bad submission: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(subs) == 0 {
+		http.Error(w, "nothing submitted", http.StatusBadRequest)
+		return
+	}
+
+	var pending []worker.Check
+	var cfgs []Config
+	for _, sub := range subs {
+		c, ok := passiveConfig(sub.Label)
+		if !ok {
+			http.Error(w, "not a passive check: " + sub.Label, http.StatusForbidden)
+			return
+		}
+
+		check, err := submissionCheck(c, sub)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		pending = append(pending, check)
+		cfgs = append(cfgs, c)
+	}
+
+	now := time.Now()
+	submitLock.Lock()
+	for _, check := range pending {
+		lastSubmit[check.ConfigLabel] = now
+	}
+	submitLock.Unlock()
+
+	results := []worker.Check{}
+	for i, check := range pending {
+		next := time.Time{}
+		if fresh := freshness(&cfgs[i]); fresh > 0 {
+			next = now.Add(fresh)
+		}
+
+		StartedRun(check.ConfigLabel)
+		FinishedRun(check.ConfigLabel, []worker.Check{check}, next)
+
+		if !TrackState(&cfgs[i], &check) {
+			UpdateLatest(check)
+			continue
+		}
+		results = append(results, RecordCheck(check))
+	}
+
+	jsn, _ := json.Marshal(SubmitResult{Accepted: len(pending), Buffered: results})
+	fmt.Fprintf(w, "%s", jsn)
+}
+
+// Do_Passive waits on passive check c, whose results come in through
+// /submit.  With a Freshness, an UNKNOWN result is reported each time that
+// many seconds go by without a submission, so a job that has stopped
+// submitting gets noticed.
+func Do_Passive(c *Config, chanl chan worker.Check, stop chan struct{}) {
+	fresh := freshness(c)
+	if fresh <= 0 {
+		<-stop
+		return
+	}
+
+	// Give a newly loaded check a full Freshness to hear from its job
+	since := time.Now()
+
+	for {
+		last := lastSubmission(c.Label)
+		if last.Before(since) {
+			last = since
+		}
+
+		next := last.Add(fresh)
+		ScheduledRun(c.Label, next)
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		// A submission came in while we waited
+		if lastSubmission(c.Label).After(last) {
+			continue
+		}
+
+		check := worker.ErrorCheck(c.Label, "passive", "no submission in " + strconv.Itoa(c.Freshness) + "s")
+		EvaluateState(c, "", &check)
+
+		hstname, err := os.Hostname()
+		if err != nil {
+			hstname = "Error Getting Hostname: " + err.Error()
+		}
+		check.Host = hstname
+		check.Tags = c.ResultTags()
+
+		since = time.Now()
+		StartedRun(c.Label)
+		FinishedRun(c.Label, []worker.Check{check}, since.Add(fresh))
+		logger.Warn("No Result Submitted For " + c.Label + " In " + strconv.Itoa(c.Freshness) + "s", logger.Fields{"label": c.Label})
+
+		if !TrackState(c, &check) {
+			UpdateLatest(check)
+			continue
+		}
+
+		select {
+		case chanl<-check:
+		case <-stop:
+			return
+		}
+	}
+}
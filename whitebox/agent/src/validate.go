@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"schedule"
+	"strconv"
+	"strings"
+	"time"
+	"validation"
+	"worker"
+	"gopkg.in/yaml.v2"
+)
+
+// ValidateConfig checks a single config.d file.  The Config is returned so
+// the caller can look for problems between files, like duplicate Labels.
+func ValidateConfig(file string, data []byte) (Config, []validation.Error) {
+	var errs []validation.Error
+	fail := func(key string, msg string) {
+		errs = append(errs, validation.Error{File: file, Line: validation.LineOf(data, key, ""), Message: msg})
+	}
+
+	c := Config{}
+	err := yaml.UnmarshalStrict(data, &c)
+	if err != nil {
+		return c, validation.YAMLErrors(file, err)
+	}
+
+	if c.Label == "" {
+		fail("Label", "missing Label")
+	}
+
+	if c.Command == "" && c.CommandType != "passive" {
+		fail("Command", "missing Command")
+	}
+
+	switch c.CommandType {
+	case "internal":
+		ct, ok := worker.Lookup(c.Command)
+		if !ok {
+			var names []string
+			for _, t := range worker.CheckTypes() {
+				names = append(names, t.Name)
+			}
+			fail("Command", "unknown internal Command " + strconv.Quote(c.Command) + " (one of " + strings.Join(names, ", ") + ")")
+			break
+		}
+
+		err := ct.ValidateParams(c.Params)
+		if err != nil {
+			key := "Params"
+			if validation.LineOf(data, key, "") == 0 {
+				key = "Command"
+			}
+			fail(key, err.Error())
+		}
+
+	case "external", "nagios", "passive":
+	case "":
+		fail("CommandType", "missing CommandType (internal, external, nagios or passive)")
+	default:
+		fail("CommandType", "unknown CommandType " + strconv.Quote(c.CommandType) + " (internal, external, nagios or passive)")
+	}
+
+	for param := range c.ParamThresholds {
+		found := false
+		for _, p := range c.Params {
+			if p == param {
+				found = true
+			}
+		}
+		if !found {
+			fail("ParamThresholds", "ParamThresholds for " + strconv.Quote(param) + ", which isn't one of the Params")
+		}
+	}
+
+	switch c.ReportMode {
+	case "", ReportAlways, ReportChange:
+	default:
+		fail("ReportMode", "unknown ReportMode " + strconv.Quote(c.ReportMode) + " (always or change)")
+	}
+
+	if c.FlapLow < 0 || c.FlapHigh < 0 || c.FlapLow > 100 || c.FlapHigh > 100 {
+		fail("FlapLow", "FlapLow and FlapHigh are percentages, 0 to 100")
+	} else if c.FlapLow > 0 && c.FlapHigh > 0 && c.FlapLow > c.FlapHigh {
+		fail("FlapLow", "FlapLow is above FlapHigh")
+	}
+
+	if c.Schedule != "" {
+		cron, err := schedule.ParseCron(c.Schedule)
+		if err != nil {
+			fail("Schedule", "bad Schedule: " + err.Error())
+		} else if cron.Next(time.Now()).IsZero() {
+			fail("Schedule", "Schedule " + strconv.Quote(c.Schedule) + " never matches")
+		}
+	}
+
+	for _, v := range []struct {
+		key   string
+		value int
+	}{
+		{"CheckFreq", c.CheckFreq},
+		{"Timeout", c.Timeout},
+		{"MaxOutput", c.MaxOutput},
+		{"Splay", c.Splay},
+		{"Jitter", c.Jitter},
+		{"Heartbeat", c.Heartbeat},
+		{"Freshness", c.Freshness},
+	} {
+		if v.value < 0 {
+			fail(v.key, v.key + " can't be negative")
+		}
+	}
+
+	return c, errs
+}
+
+// ValidateAgentConfig checks the agent wide settings file.
+func ValidateAgentConfig(file string, data []byte) []validation.Error {
+	var errs []validation.Error
+	fail := func(key string, msg string) {
+		errs = append(errs, validation.Error{File: file, Line: validation.LineOf(data, key, ""), Message: msg})
+	}
+
+	ac := AgentConfig{}
+	err := yaml.UnmarshalStrict(data, &ac)
+	if err != nil {
+		return validation.YAMLErrors(file, err)
+	}
+
+	if ac.Push.URL != "" {
+		u, err := url.Parse(ac.Push.URL)
+		if err != nil {
+			fail("URL", "bad Push URL: " + err.Error())
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			fail("URL", "Push URL must be http or https")
+		}
+	}
+	if ac.Push.URL != "" && ac.Push.Token == "" && ac.Push.Secret == "" {
+		fail("Push", "Push needs a Token or Secret, the scraper only takes authenticated pushes")
+	}
+	if ac.Push.Interval < 0 || ac.Push.BatchSize < 0 || ac.Push.MaxBackoff < 0 {
+		fail("Push", "Push Interval, BatchSize and MaxBackoff can't be negative")
+	}
+	if ac.MaxBuffer < 0 {
+		fail("MaxBuffer", "MaxBuffer can't be negative")
+	}
+
+	for _, cred := range ac.Auth.Credentials {
+		if cred.Name == "" {
+			fail("Credentials", "credential without a Name")
+		}
+		if cred.Token == "" && cred.Secret == "" {
+			fail("Credentials", "credential " + strconv.Quote(cred.Name) + " needs a Token or a Secret")
+		}
+		if _, ok := scopeLevels[cred.Scope]; !ok {
+			fail("Credentials", "credential " + strconv.Quote(cred.Name) + " has unknown Scope " + strconv.Quote(cred.Scope) + " (read, consume, admin or submit)")
+		}
+	}
+
+	for _, mw := range ac.Maintenance {
+		_, err := mw.Active(time.Now())
+		if err != nil {
+			fail("Maintenance", "maintenance window " + strconv.Quote(mw.Name) + ": " + err.Error())
+		}
+	}
+
+	return errs
+}
+
+// Validate checks every file in config.d and the agent config, printing
+// any problems as file:line: message.  It returns the exit status for the
+// validate subcommand.
+func Validate() int {
+	var errs []validation.Error
+
+	files, err := ioutil.ReadDir(configDir)
+	if err != nil {
+		fmt.Println("Error Reading " + configDir + ": " + err.Error())
+		return 1
+	}
+
+	checked := 0
+	labels := make(map[string][]string)
+	deps := make(map[string][]string)
+	data := make(map[string][]byte)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		checked++
+
+		path := filepath.Join(configDir, f.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs = append(errs, validation.Error{File: path, Message: err.Error()})
+			continue
+		}
+
+		c, cerrs := ValidateConfig(path, b)
+		errs = append(errs, cerrs...)
+		if c.Label != "" {
+			labels[c.Label] = append(labels[c.Label], path)
+			deps[c.Label] = c.DependsOn
+			data[path] = b
+		}
+	}
+
+	for label, parents := range deps {
+		path := labels[label][0]
+		for _, parent := range parents {
+			if _, ok := deps[parent]; !ok {
+				errs = append(errs, validation.Error{File: path, Line: validation.LineOf(data[path], "DependsOn", ""), Message: "DependsOn " + strconv.Quote(parent) + ", which isn't a configured Label"})
+			}
+		}
+	}
+
+	for _, cycle := range dependencyCycles(deps) {
+		path := labels[cycle[0]][0]
+		errs = append(errs, validation.Error{File: path, Line: validation.LineOf(data[path], "DependsOn", ""), Message: "dependency cycle: " + strings.Join(append(cycle, cycle[0]), " -> ")})
+	}
+
+	for label, paths := range labels {
+		if len(paths) > 1 {
+			for _, path := range paths {
+				errs = append(errs, validation.Error{File: path, Message: "duplicate Label " + strconv.Quote(label) + " (also in " + strings.Join(others(paths, path), ", ") + ")"})
+			}
+		}
+	}
+
+	b, err := ioutil.ReadFile(agentConfigFile)
+	if err == nil {
+		errs = append(errs, ValidateAgentConfig(agentConfigFile, b)...)
+	}
+
+	return validation.Report(errs, checked)
+}
+
+func others(list []string, exclude string) []string {
+	var out []string
+	for _, s := range list {
+		if s != exclude {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
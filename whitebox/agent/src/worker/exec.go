@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const DefaultTimeout = 60 * time.Second
+const DefaultMaxOutput = 64 * 1024
+
+// How long to wait for a command's output once it has exited or been killed.
+// A child that left the process group can hold stdout/stderr open long after
+// that, and the check mustn't hang waiting for it.
+const outputWaitDelay = 5 * time.Second
+
+// ExecOptions controls how an external command is run.  A zero Timeout or
+// MaxOutput means use the default.
+type ExecOptions struct {
+	Args      []string
+	Timeout   time.Duration
+	MaxOutput int
+}
+
+type ExecResult struct {
+	Stdout    string
+	Stderr    string
+	ExitCode  int
+	Elapsed   time.Duration
+	TimedOut  bool
+	Truncated bool
+}
+
+// limitedBuffer keeps the first limit bytes written to it and quietly drops
+// the rest, so a chatty command can't use up the agent's memory.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	room := l.limit - l.buf.Len()
+	if room < len(p) {
+		l.truncated = true
+		if room > 0 {
+			l.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+
+	return l.buf.Write(p)
+}
+
+// RunCommand runs pth in its own process group, killing the whole group if
+// it's still going after the timeout.  Output still held open by anything
+// that escaped the group is given up on after outputWaitDelay.  The returned error is only for
+// failures to start the command, a non-zero exit is reported in ExitCode.
+func RunCommand(pth string, opts ExecOptions) (ExecResult, error) {
+	result := ExecResult{}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	maxoutput := opts.MaxOutput
+	if maxoutput <= 0 {
+		maxoutput = DefaultMaxOutput
+	}
+
+	stdout := &limitedBuffer{limit: maxoutput}
+	stderr := &limitedBuffer{limit: maxoutput}
+
+	cmd := exec.Command(pth, opts.Args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.WaitDelay = outputWaitDelay
+
+	start := time.Now()
+	err := cmd.Start()
+	if err != nil {
+		return result, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err = <-done:
+	case <-timer.C:
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		err = <-done
+		result.TimedOut = true
+	}
+
+	result.Elapsed = time.Since(start)
+	result.Stdout = stdout.buf.String()
+	result.Stderr = stderr.buf.String()
+	result.Truncated = stdout.truncated || stderr.truncated
+
+	// The command itself exited cleanly, only its output was left open
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+
+	if err != nil {
+		exiterr, ok := err.(*exec.ExitError)
+		if !ok {
+			return result, err
+		}
+
+		result.ExitCode = -1
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+			result.ExitCode = status.ExitStatus()
+		}
+	}
+
+	return result, nil
+}
+
+// TimeoutCheck fills in check for a command that had to be killed.
+func TimeoutCheck(check *Check, result ExecResult) error {
+	check.Output = "timed out after " + result.Elapsed.Round(time.Millisecond).String()
+	check.Stderr = result.Stderr
+	check.Retval = 1
+	check.State = StateUnknown
+	check.Duration = result.Elapsed.Seconds()
+
+	return errors.New("command timed out after " + strconv.FormatFloat(result.Elapsed.Seconds(), 'f', 1, 64) + "s")
+}
@@ -0,0 +1,192 @@
+package worker
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Nagios plugin exit codes, in order.
+var nagiosStates = []string{StateOK, StateWarning, StateCritical, StateUnknown}
+
+// Perfdata units and what to multiply by to get to the base unit we report.
+var perfUnits = map[string]struct {
+	Unit  string
+	Scale float64
+}{
+	"s":  {"seconds", 1},
+	"ms": {"seconds", 0.001},
+	"us": {"seconds", 0.000001},
+	"%":  {"percent", 1},
+	"B":  {"bytes", 1},
+	"KB": {"bytes", 1024},
+	"MB": {"bytes", 1024 * 1024},
+	"GB": {"bytes", 1024 * 1024 * 1024},
+	"TB": {"bytes", 1024 * 1024 * 1024 * 1024},
+	"c":  {"count", 1},
+}
+
+func NagiosState(code int) string {
+	if code < 0 || code >= len(nagiosStates) {
+		return StateUnknown
+	}
+
+	return nagiosStates[code]
+}
+
+// SplitNagiosOutput separates plugin output into the status text and the
+// perfdata.  The first line is "TEXT | PERFDATA", any following lines are
+// long text, optionally followed by "| PERFDATA" that runs to the end.
+func SplitNagiosOutput(out string) (string, string) {
+	lines := strings.SplitN(strings.TrimRight(out, "\n"), "\n", 2)
+
+	text, perf := splitPipe(lines[0])
+	if len(lines) > 1 {
+		longtext, longperf := splitPipe(lines[1])
+		if longtext != "" {
+			text += "\n" + longtext
+		}
+		if longperf != "" {
+			perf = strings.TrimSpace(perf + " " + longperf)
+		}
+	}
+
+	return text, perf
+}
+
+func splitPipe(s string) (string, string) {
+	i := strings.Index(s, "|")
+	if i < 0 {
+		return strings.TrimSpace(s), ""
+	}
+
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+}
+
+// ParsePerfData turns "'label'=value[UOM];[warn];[crit];[min];[max] ..." into
+// metrics.  Entries that can't be parsed are skipped.
+func ParsePerfData(perf string) []Metric {
+	var metrics []Metric
+
+	for _, item := range splitPerfItems(perf) {
+		eq := strings.Index(item, "=")
+		if strings.HasPrefix(item, "'") {
+			eq = strings.Index(item, "'=") + 1
+		}
+		if eq < 1 {
+			continue
+		}
+
+		label := item[:eq]
+		if strings.HasPrefix(label, "'") && strings.HasSuffix(label, "'") && len(label) > 1 {
+			label = strings.Replace(label[1:len(label)-1], "''", "'", -1)
+		}
+
+		value := strings.SplitN(item[eq+1:], ";", 2)[0]
+		end := len(value)
+		for end > 0 && !strings.ContainsAny(value[end-1:end], "0123456789.") {
+			end--
+		}
+
+		number, err := strconv.ParseFloat(value[:end], 64)
+		if err != nil {
+			continue
+		}
+
+		m := Metric{Name: label, Value: number}
+		if uom := value[end:]; uom != "" {
+			if u, ok := perfUnits[uom]; ok {
+				m.Unit = u.Unit
+				m.Value = number * u.Scale
+			} else {
+				m.Unit = uom
+			}
+		}
+
+		metrics = append(metrics, m)
+	}
+
+	return metrics
+}
+
+// splitPerfItems splits perfdata on whitespace, except inside quoted labels.
+func splitPerfItems(perf string) []string {
+	var items []string
+
+	current := ""
+	quoted := false
+	for _, r := range perf {
+		if r == '\'' {
+			quoted = !quoted
+		}
+
+		if unicode.IsSpace(r) && !quoted {
+			if current != "" {
+				items = append(items, current)
+			}
+			current = ""
+			continue
+		}
+
+		current += string(r)
+	}
+
+	if current != "" {
+		items = append(items, current)
+	}
+
+	return items
+}
+
+// RunNagios runs a Nagios compatible plugin and maps its exit code and output
+// onto a Check.  opts.Args carries the Params from the config.
+func RunNagios(Label string, pth string, opts ExecOptions) (Check, error) {
+	check := Check{}
+
+	now := time.Now()
+	current_time := time.Now().Local()
+
+	epoch := now.Unix()
+	t := current_time.Format("Jan 02 2006 03:04:05")
+
+	check.ConfigLabel = Label
+	check.TimeStamp = t
+	check.EpochTime = epoch
+	check.Command = strings.TrimSpace(pth + " " + strings.Join(opts.Args, " "))
+
+	_, err := os.Stat(pth)
+	if os.IsNotExist(err) {
+		check.Output = "can't find nagios plugin: " + pth
+		check.Retval = 3
+		check.State = StateUnknown
+		return check, err
+	}
+
+	result, err := RunCommand(pth, opts)
+	if err != nil {
+		check.Output = "failed to run (" + pth + "):" + err.Error()
+		check.Retval = 3
+		check.State = StateUnknown
+		return check, err
+	}
+
+	if result.TimedOut {
+		return check, TimeoutCheck(&check, result)
+	}
+
+	text, perf := SplitNagiosOutput(result.Stdout)
+
+	check.Output = text
+	if result.Truncated {
+		check.Output += " [output truncated]"
+	}
+	check.Stderr = result.Stderr
+	check.Duration = result.Elapsed.Seconds()
+	check.Retval = result.ExitCode
+	check.State = NagiosState(result.ExitCode)
+	check.Metrics = ParsePerfData(perf)
+
+	return check, nil
+}
@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type ParamSpec struct {
+	Name        string
+	Description string
+}
+
+// CheckType describes an internal check.  Params documents what goes in the
+// config's Params list, MinParams/MaxParams bound its length (MaxParams < 0
+// means no limit).  When PerParam is set Run is called once for every Param
+// instead of once with the whole list.
+type CheckType struct {
+	Name        string
+	Description string
+	Params      []ParamSpec
+	MinParams   int
+	MaxParams   int
+	PerParam    bool
+	Run         func(Label string, Params []string) (Check, error) `json:"-"`
+}
+
+var registry = make(map[string]CheckType)
+var registryLock sync.RWMutex
+
+// Register makes a check type available to the agent under ct.Name,
+// replacing any check already registered with that name.
+func Register(ct CheckType) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registry[ct.Name] = ct
+}
+
+func Lookup(name string) (CheckType, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	ct, ok := registry[name]
+	return ct, ok
+}
+
+func CheckTypes() []CheckType {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	types := make([]CheckType, 0, len(registry))
+	for _, ct := range registry {
+		types = append(types, ct)
+	}
+
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+func (ct CheckType) ValidateParams(params []string) error {
+	if len(params) < ct.MinParams {
+		return errors.New(ct.Name + " needs at least " + strconv.Itoa(ct.MinParams) + " Params, got " + strconv.Itoa(len(params)))
+	}
+
+	if ct.MaxParams >= 0 && len(params) > ct.MaxParams {
+		return errors.New(ct.Name + " takes at most " + strconv.Itoa(ct.MaxParams) + " Params, got " + strconv.Itoa(len(params)))
+	}
+
+	return nil
+}
+
+// ErrorCheck builds the UNKNOWN result reported when a check couldn't be run
+// at all.
+func ErrorCheck(Label string, Command string, Output string) Check {
+	check := Check{}
+
+	now := time.Now()
+	current_time := time.Now().Local()
+
+	check.ConfigLabel = Label
+	check.TimeStamp = current_time.Format("Jan 02 2006 03:04:05")
+	check.EpochTime = now.Unix()
+	check.Command = Command
+	check.Output = Output
+	check.Retval = 1
+	check.State = StateUnknown
+
+	return check
+}
+
+func init() {
+	Register(CheckType{
+		Name:        "LoadAverage",
+		Description: "1, 5 and 15 minute load averages from /proc/loadavg",
+		MaxParams:   0,
+		Run: func(Label string, Params []string) (Check, error) {
+			return LoadAverage(Label)
+		},
+	})
+
+	Register(CheckType{
+		Name:        "MemUsage",
+		Description: "Memory in use, from /proc/meminfo",
+		MaxParams:   0,
+		Run: func(Label string, Params []string) (Check, error) {
+			return MemUsage(Label)
+		},
+	})
+
+	Register(CheckType{
+		Name:        "CheckSSH",
+		Description: "Whether sshd is listening",
+		MaxParams:   0,
+		Run: func(Label string, Params []string) (Check, error) {
+			return CheckSSH(Label)
+		},
+	})
+
+	Register(CheckType{
+		Name:        "CheckSwap",
+		Description: "Swap in use, from /proc/meminfo",
+		MaxParams:   0,
+		Run: func(Label string, Params []string) (Check, error) {
+			return CheckSwap(Label)
+		},
+	})
+
+	Register(CheckType{
+		Name:        "CheckMailQ",
+		Description: "Number of messages in /var/spool/clientmqueue",
+		MaxParams:   0,
+		Run: func(Label string, Params []string) (Check, error) {
+			return CheckMailQ(Label)
+		},
+	})
+
+	Register(CheckType{
+		Name:        "CheckDiskUsage",
+		Description: "Disk and inode usage of each filesystem",
+		Params:      []ParamSpec{ParamSpec{Name: "path", Description: "Mount point to check, one per Param"}},
+		MinParams:   1,
+		MaxParams:   -1,
+		PerParam:    true,
+		Run: func(Label string, Params []string) (Check, error) {
+			return CheckDiskUsage(Label, Params[0])
+		},
+	})
+
+	Register(CheckType{
+		Name:        "CheckInodes",
+		Description: "Inode usage of each filesystem",
+		Params:      []ParamSpec{ParamSpec{Name: "path", Description: "Mount point to check, one per Param"}},
+		MinParams:   1,
+		MaxParams:   -1,
+		PerParam:    true,
+		Run: func(Label string, Params []string) (Check, error) {
+			return CheckInodes(Label, Params[0])
+		},
+	})
+
+	Register(CheckType{
+		Name:        "CheckPassword",
+		Description: "Password expiration of each user in /etc/shadow",
+		Params:      []ParamSpec{ParamSpec{Name: "user", Description: "User to check, one per Param"}},
+		MinParams:   1,
+		MaxParams:   -1,
+		PerParam:    true,
+		Run: func(Label string, Params []string) (Check, error) {
+			return CheckPassword(Label, Params[0])
+		},
+	})
+
+	Register(CheckType{
+		Name:        "CheckNTPSkew",
+		Description: "Clock offset against each NTP server",
+		Params:      []ParamSpec{ParamSpec{Name: "server", Description: "NTP server to query, one per Param"}},
+		MinParams:   1,
+		MaxParams:   -1,
+		PerParam:    true,
+		Run: func(Label string, Params []string) (Check, error) {
+			return CheckNTPSkew(Label, Params[0])
+		},
+	})
+
+	Register(CheckType{
+		Name:        "FindFilePerms",
+		Description: "Files with a given name under a directory that don't have the expected permissions",
+		Params: []ParamSpec{
+			ParamSpec{Name: "rootpath", Description: "Directory to search"},
+			ParamSpec{Name: "filename", Description: "File name to look for"},
+			ParamSpec{Name: "permissions", Description: "Expected permissions, e.g. -rw-r--r--"},
+		},
+		MinParams: 3,
+		MaxParams: 3,
+		Run: func(Label string, Params []string) (Check, error) {
+			return FindFilePerms(Label, Params[0], Params[1], Params[2])
+		},
+	})
+}
@@ -5,11 +5,12 @@ import (
 	"io/ioutil"
 	"strconv"
 	"os"
-	"os/exec"
+	"errors"
 	"syscall"
 	"bufio"
 	"strings"
 	"path/filepath"
+	"math"
 	linuxproc "github.com/c9s/goprocinfo/linux"
 	"github.com/drael/GOnetstat"
         "github.com/beevik/ntp"
@@ -19,13 +20,45 @@ import (
 type Check struct {
         ConfigLabel string
 	Host string
+	Param string
         TimeStamp string
         EpochTime int64
         Command string
         Output string
         Retval int
+	State string
+	Value float64
+	Metrics []Metric
+	Stderr string
+	Duration float64
+	Seq uint64
+	Silenced bool
+	SilenceReason string
+	Tags map[string]string
+	Skipped bool
+	SkipReason string
+	Flapping bool
+	PercentStateChange float64
 }
 
+// Metric is a single numeric measurement taken by a check, so consumers
+// don't have to pick apart Output.
+type Metric struct {
+	Name string
+	Value float64
+	Unit string
+	Labels map[string]string
+}
+
+// Nagios style states.  Retval only says whether the check could gather its
+// data, State says how healthy the thing being checked is.
+const (
+	StateOK = "OK"
+	StateWarning = "WARNING"
+	StateCritical = "CRITICAL"
+	StateUnknown = "UNKNOWN"
+)
+
 type Shadow struct {
 	Username string
 	Encpass string
@@ -56,6 +89,7 @@ func LoadAverage(Label string) (Check, error) {
 		loadavg.Command = "LoadAverage"
 		loadavg.Output = "error reading /proc/loadavg: " + err.Error() 
 		loadavg.Retval = 1
+		loadavg.State = StateUnknown
 
 		return loadavg, err
 	}
@@ -66,6 +100,25 @@ func LoadAverage(Label string) (Check, error) {
 	loadavg.Command = "LoadAverage"
 	loadavg.Output = string(data)
 	loadavg.Retval = 0
+	loadavg.State = StateOK
+
+	fields := strings.Fields(string(data))
+	for i, name := range []string{"load1", "load5", "load15"} {
+		if i >= len(fields) {
+			break
+		}
+
+		value, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			continue
+		}
+
+		loadavg.Metrics = append(loadavg.Metrics, Metric{Name: name, Value: value})
+	}
+
+	if len(loadavg.Metrics) > 0 {
+		loadavg.Value = loadavg.Metrics[0].Value
+	}
 
 	return loadavg, nil	
 }
@@ -88,6 +141,7 @@ func MemUsage(Label string) (Check, error) {
 		memusage.Command = "MemUsage"
 		memusage.Output = "error reading /proc/meminfo: " + err.Error() 
 		memusage.Retval = 1
+		memusage.State = StateUnknown
 
 		return memusage, err
 	}
@@ -102,6 +156,13 @@ func MemUsage(Label string) (Check, error) {
 	memusage.Command = "MemUsage"
 	memusage.Output = strconv.FormatUint(memused, 10) + "/" + strconv.FormatUint(data.MemTotal, 10) + "/" + memusedperc + "%%"
 	memusage.Retval = 0
+	memusage.State = StateOK
+	memusage.Value = musedperc
+	memusage.Metrics = []Metric{
+		Metric{Name: "memory_used", Value: float64(memused * 1024), Unit: "bytes"},
+		Metric{Name: "memory_total", Value: float64(data.MemTotal * 1024), Unit: "bytes"},
+		Metric{Name: "memory_used", Value: musedperc, Unit: "percent"},
+	}
 
 	return memusage, nil
 }
@@ -109,8 +170,25 @@ func MemUsage(Label string) (Check, error) {
 func CheckDiskUsage(Label string, Path string) (Check, error) {
 	disk := Check{}
 
+	now := time.Now()
+	current_time := time.Now().Local()
+
+	epoch := now.Unix()
+	t := current_time.Format("Jan 02 2006 03:04:05")
+
 	var stat syscall.Statfs_t
-	syscall.Statfs(Path, &stat)	
+	err := syscall.Statfs(Path, &stat)
+	if err != nil {
+		disk.ConfigLabel = Label
+		disk.Param = Path
+		disk.TimeStamp = t
+		disk.EpochTime = epoch
+		disk.Command = "CheckDiskUsage"
+		disk.Output = "error reading filesystem stats for " + Path + ": " + err.Error()
+		disk.Retval = 1
+		disk.State = StateUnknown
+		return disk, err
+	}
 
 	disktotal := stat.Blocks * uint64(stat.Bsize)
 	diskfree := stat.Bavail * uint64(stat.Bsize)
@@ -132,23 +210,50 @@ func CheckDiskUsage(Label string, Path string) (Check, error) {
 	strinodefree := strconv.FormatUint(inodefree, 10)
 	strinodeused := strconv.FormatUint(inodeused, 10)
 
-	now := time.Now()
-	current_time := time.Now().Local()
-
-	epoch := now.Unix()
-	t := current_time.Format("Jan 02 2006 03:04:05")
-
-	disk.ConfigLabel = Path + " " + Label
+	disk.ConfigLabel = Label
+	disk.Param = Path
 	disk.TimeStamp = t
 	disk.EpochTime = epoch
 	disk.Command = "CheckDiskUsage"
 	disk.Output = strdisktotal + "|" + strdiskused + "|" + strdiskfree + "|" + diskusedperc + "%%" + "|" + strinodetotal + "|" + strinodeused + "|" + strinodefree + "|" + inodeusedperc + "%%"  
 	disk.Retval = 0
+	disk.State = StateOK
+	disk.Value = dskusedperc
+
+	labels := map[string]string{"path": Path}
+	disk.Metrics = []Metric{
+		Metric{Name: "disk_total", Value: float64(disktotal), Unit: "bytes", Labels: labels},
+		Metric{Name: "disk_used", Value: float64(diskused), Unit: "bytes", Labels: labels},
+		Metric{Name: "disk_free", Value: float64(diskfree), Unit: "bytes", Labels: labels},
+		Metric{Name: "disk_used", Value: dskusedperc, Unit: "percent", Labels: labels},
+		Metric{Name: "inode_total", Value: float64(inodetotal), Unit: "inodes", Labels: labels},
+		Metric{Name: "inode_used", Value: float64(inodeused), Unit: "inodes", Labels: labels},
+		Metric{Name: "inode_free", Value: float64(inodefree), Unit: "inodes", Labels: labels},
+		Metric{Name: "inode_used", Value: indeusedperc, Unit: "percent", Labels: labels},
+	}
 
 	return disk, nil	
 
 }
 
+// CheckInodes is CheckDiskUsage with the inode usage percentage as its Value,
+// so thresholds apply to inodes rather than space.
+func CheckInodes(Label string, Path string) (Check, error) {
+	inodes, err := CheckDiskUsage(Label, Path)
+	inodes.Command = "CheckInodes"
+	if err != nil {
+		return inodes, err
+	}
+
+	for _, m := range inodes.Metrics {
+		if m.Name == "inode_used" && m.Unit == "percent" {
+			inodes.Value = m.Value
+		}
+	}
+
+	return inodes, nil
+}
+
 func CheckPassword(Label string, User string) (Check, error) {
 	user := Check{}
 	shadow := Shadow{}
@@ -163,11 +268,13 @@ func CheckPassword(Label string, User string) (Check, error) {
 	file, err := os.Open("/etc/shadow")
 	if err != nil {
 		user.ConfigLabel = Label
+		user.Param = User
 		user.TimeStamp = t
 		user.EpochTime = epoch
 		user.Command = "CheckPassword: [" + User + "]"
 		user.Output = "error reading /etc/shadow: " + err.Error() 
 		user.Retval = 1
+		user.State = StateUnknown
 
 		return user, err
 	}
@@ -206,15 +313,20 @@ func CheckPassword(Label string, User string) (Check, error) {
 	changed := shadow.Lastchg * scale
 
 	expires := ""
+	expired := false
+	daysleft := float64(0)
 	if shadow.Lastchg <= 0 || shadow.Maxdays >= 10000 * (day / scale) || shadow.Maxdays < 0 {
 		expires = "never"
 	} else {
 		iexpires := changed + shadow.Maxdays * scale
 		expires = strconv.Itoa(iexpires)
+		expired = int64(iexpires) < epoch
+		daysleft = float64(int64(iexpires) - epoch) / float64(day)
 	}
 
 
 	user.ConfigLabel = Label
+	user.Param = User
 	user.TimeStamp = t
 	user.EpochTime = epoch
 	user.Command = "CheckPassword: [" + User + "]"
@@ -222,9 +334,20 @@ func CheckPassword(Label string, User string) (Check, error) {
 	if flag {
 		user.Output = expires 
 		user.Retval = 0
+		user.State = StateOK
+		if expired {
+			user.State = StateCritical
+		}
+
+		if expires != "never" {
+			user.Metrics = []Metric{
+				Metric{Name: "password_expiry", Value: daysleft, Unit: "days", Labels: map[string]string{"user": User}},
+			}
+		}
 	} else {
 		user.Output = "user doesn't exist"
 		user.Retval = 1
+		user.State = StateUnknown
 	}
 
 	return user, nil	
@@ -258,9 +381,13 @@ func CheckSSH(Label string) (Check, error) {
 	if flag {
 		ssh.Output = "SSH is up"
 		ssh.Retval = 0
+		ssh.State = StateOK
+		ssh.Metrics = []Metric{Metric{Name: "ssh_up", Value: 1}}
 	} else {
 		ssh.Output = "SSH is DOWN"
 		ssh.Retval = 1
+		ssh.State = StateCritical
+		ssh.Metrics = []Metric{Metric{Name: "ssh_up", Value: 0}}
 	}
 
 	return ssh, nil	
@@ -283,18 +410,30 @@ func CheckSwap(Label string) (Check, error) {
 		swapusage.Command = "CheckSwap"
 		swapusage.Output = "error reading /proc/meminfo: " + err.Error()
 		swapusage.Retval = 1
+		swapusage.State = StateUnknown
 		return swapusage, err
 	}
 
 	swapused := data.SwapTotal - data.SwapFree
-	swpusedperc := float64((float64(swapused) / float64(data.SwapTotal)) * 100)
+	swpusedperc := float64(0)
+	if data.SwapTotal > 0 {
+		swpusedperc = float64((float64(swapused) / float64(data.SwapTotal)) * 100)
+	}
 	swapusedperc := strconv.FormatFloat(swpusedperc, 'f', 0, 64) 
 
         swapusage.ConfigLabel = Label
         swapusage.TimeStamp = t
         swapusage.EpochTime = epoch
+	swapusage.Command = "CheckSwap"
 	swapusage.Output = strconv.FormatUint(swapused, 10) + "/" + strconv.FormatUint(data.SwapTotal, 10) + "/" + swapusedperc + "%%"
 	swapusage.Retval = 0
+	swapusage.State = StateOK
+	swapusage.Value = swpusedperc
+	swapusage.Metrics = []Metric{
+		Metric{Name: "swap_used", Value: float64(swapused * 1024), Unit: "bytes"},
+		Metric{Name: "swap_total", Value: float64(data.SwapTotal * 1024), Unit: "bytes"},
+		Metric{Name: "swap_used", Value: swpusedperc, Unit: "percent"},
+	}
 
 	return swapusage, nil
 }
@@ -311,11 +450,13 @@ func CheckNTPSkew(Label string, Server string) (Check, error) {
         response, err := ntp.Query(Server)
         if err != nil {
 		ntpskew.ConfigLabel = Label
+		ntpskew.Param = Server
 		ntpskew.TimeStamp = t
 		ntpskew.EpochTime = epoch
 		ntpskew.Command = "CheckNTPSkew"
 		ntpskew.Output = "error querying ntp server: " + err.Error() 
 		ntpskew.Retval = 1
+		ntpskew.State = StateUnknown
 	
 		return ntpskew, err
         }
@@ -324,11 +465,17 @@ func CheckNTPSkew(Label string, Server string) (Check, error) {
 
 
         ntpskew.ConfigLabel = Label
+        ntpskew.Param = Server
         ntpskew.TimeStamp = t
         ntpskew.EpochTime = epoch
 	ntpskew.Command = "CheckNTPSkew"
 	ntpskew.Output = offset 
 	ntpskew.Retval = 0
+	ntpskew.State = StateOK
+	ntpskew.Value = math.Abs(response.ClockOffset.Seconds())
+	ntpskew.Metrics = []Metric{
+		Metric{Name: "ntp_offset", Value: response.ClockOffset.Seconds(), Unit: "seconds", Labels: map[string]string{"server": Server}},
+	}
 
 	return ntpskew, nil
 }
@@ -350,6 +497,7 @@ func CheckMailQ(Label string) (Check, error) {
 		check.Command = "CheckMailQ"
 		check.Output = "/var/spool/clientmqueue doesn't exist" 
 		check.Retval = 1
+		check.State = StateUnknown
 		return check, err
         }
 
@@ -361,6 +509,7 @@ func CheckMailQ(Label string) (Check, error) {
 		check.Command = "CheckMailQ"
 		check.Output = "Can't read from /var/spool/clientmqueue/" + err.Error()
 		check.Retval = 1
+		check.State = StateUnknown
 		return check, err
         }
 
@@ -370,6 +519,9 @@ func CheckMailQ(Label string) (Check, error) {
 	check.Command = "CheckMailQ"
 	check.Output = strconv.Itoa(len(files)) 
 	check.Retval = 0
+	check.State = StateOK
+	check.Value = float64(len(files))
+	check.Metrics = []Metric{Metric{Name: "mailq", Value: float64(len(files)), Unit: "messages"}}
 
 	return check, nil
 }
@@ -406,19 +558,25 @@ func FindFilePerms(Label string, rootpath string, filename string, permissions s
 		check.Command = "FindFilePerms"
 		check.Output = "Error Walking rootpath: " + err.Error()
 		check.Retval = 1
+		check.State = StateUnknown
 		return check, err
         }
 
 	flag := false
+	badcount := 0
         for i, p := range list {
                 strperm := p.Perm().String()
                 if strperm != permissions {
 			flag = true
 			badperms += i + "|"
+			badcount++
                 }
         }
 
 	badperms = strings.TrimSuffix(badperms, "|")
+	check.Metrics = []Metric{
+		Metric{Name: "bad_permissions", Value: float64(badcount), Unit: "files", Labels: map[string]string{"path": rootpath, "file": filename}},
+	}
 
 	if flag {
 		check.ConfigLabel = Label
@@ -427,6 +585,7 @@ func FindFilePerms(Label string, rootpath string, filename string, permissions s
 		check.Command = "FindFilePerms"
 		check.Output = "Bad Permissions: " + badperms
 		check.Retval = 1
+		check.State = StateCritical
 		return check, err
 	}
 
@@ -436,10 +595,11 @@ func FindFilePerms(Label string, rootpath string, filename string, permissions s
 	check.Command = "FindFilePerms"
 	check.Output = "Success: All Files Have Correct Permissions" 
 	check.Retval = 0
+	check.State = StateOK
 	return check, nil
 }
 
-func RunExternal(Label string, pth string) (Check, error) {
+func RunExternal(Label string, pth string, opts ExecOptions) (Check, error) {
 	check := Check{}
 
         now := time.Now()
@@ -448,34 +608,47 @@ func RunExternal(Label string, pth string) (Check, error) {
         epoch := now.Unix()
         t := current_time.Format("Jan 02 2006 03:04:05")
 
+	check.ConfigLabel = Label
+	check.TimeStamp = t
+	check.EpochTime = epoch
+	check.Command = pth
+
 	_, err := os.Stat(pth)
 	if os.IsNotExist(err) {
-		check.ConfigLabel = Label
-		check.TimeStamp = t
-		check.EpochTime = epoch
-		check.Command = pth
 		check.Output = "can't find external utility: " + pth 
 		check.Retval = 1
+		check.State = StateUnknown
 		return check, err
 	}
 
-	out, err := exec.Command(pth).Output()
+	result, err := RunCommand(pth, opts)
 	if err != nil {
-		check.ConfigLabel = Label
-		check.TimeStamp = t
-		check.EpochTime = epoch
-		check.Command = pth
 		check.Output = "failed to run (" + pth + "):" + err.Error()
 		check.Retval = 1
+		check.State = StateUnknown
 		return check, err
 	}
 
-        check.ConfigLabel = Label
-        check.TimeStamp = t
-        check.EpochTime = epoch
-	check.Command = pth
-	check.Output = "Success: " + string(out) 
+	if result.TimedOut {
+		return check, TimeoutCheck(&check, result)
+	}
+
+	check.Stderr = result.Stderr
+	check.Duration = result.Elapsed.Seconds()
+
+	if result.ExitCode != 0 {
+		check.Output = "failed to run (" + pth + "): exit status " + strconv.Itoa(result.ExitCode)
+		check.Retval = 1
+		check.State = StateCritical
+		return check, errors.New(check.Output)
+	}
+
+	check.Output = "Success: " + result.Stdout
+	if result.Truncated {
+		check.Output += " [output truncated]"
+	}
 	check.Retval = 0
+	check.State = StateOK
 
 	return check, nil
 }
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"logger"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"signing"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"gopkg.in/yaml.v2"
+)
+
+// A bundle is a directory under BundlePath holding check definitions, in
+// the same format as an agent's config.d.  Hosts get the bundles listed in
+// their Bundles, plus those GroupBundles gives each of their Groups.
+
+// DriftReport is what a bundle sync found different on an agent before it
+// put it right.  Missing are bundle checks the agent didn't have, Changed
+// ones it had with different contents, and Extra ones an earlier sync put
+// there that are no longer in any of the host's bundles.  Conflicts are
+// bundle checks whose Label is already used by a check written by hand on
+// the agent; those are left alone for someone to sort out.
+type DriftReport struct {
+	Host      string
+	Time      string
+	EpochTime int64
+	Bundles   []string
+	Missing   []string
+	Changed   []string
+	Extra     []string
+	Conflicts []string
+	Errors    []string
+}
+
+// agentConfigEntry is the part of the agent's /config listing we need.
+type agentConfigEntry struct {
+	Label    string `json:"Label"`
+	File     string `json:"File"`
+	Managed  bool   `json:"Managed"`
+	Checksum string `json:"Checksum"`
+}
+
+var drift = make(map[string]DriftReport)
+var driftLock sync.Mutex
+
+// HostBundles returns the names of the bundles host i of c should have.
+func HostBundles(c *Config, i int) []string {
+	seen := make(map[string]bool)
+	var names []string
+
+	add := func(list []string) {
+		for _, name := range list {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	add(c.Hosts[i].Bundles)
+	for _, group := range c.Hosts[i].Groups {
+		add(c.GroupBundles[group])
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// LoadBundles reads the check definitions in the named bundles, keyed by
+// their Label.
+func LoadBundles(bundlepath string, names []string) (map[string][]byte, error) {
+	defs := make(map[string][]byte)
+	from := make(map[string]string)
+
+	for _, name := range names {
+		dir := filepath.Join(bundlepath, name)
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, errors.New("bundle " + name + ": " + err.Error())
+		}
+
+		for _, f := range files {
+			ext := filepath.Ext(f.Name())
+			if f.IsDir() || (ext != ".yml" && ext != ".yaml") {
+				continue
+			}
+
+			b, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			var def struct {
+				Label string `yaml:"Label"`
+			}
+			err = yaml.Unmarshal(b, &def)
+			if err != nil {
+				return nil, errors.New(filepath.Join(dir, f.Name()) + ": " + err.Error())
+			}
+			if def.Label == "" {
+				return nil, errors.New(filepath.Join(dir, f.Name()) + ": missing Label")
+			}
+			if other, ok := from[def.Label]; ok {
+				return nil, errors.New("Label " + def.Label + " is in both " + other + " and " + filepath.Join(dir, f.Name()))
+			}
+
+			defs[def.Label] = b
+			from[def.Label] = filepath.Join(dir, f.Name())
+		}
+	}
+
+	return defs, nil
+}
+
+// agentConfigRequest calls the agent's /config endpoint.
+func agentConfigRequest(client *http.Client, c *Config, i int, method string, query string, body []byte) ([]byte, error) {
+	h := c.Hosts[i]
+
+	cfgurl := h.TLS.Scheme() + h.HostName + "/config" + query
+	req, err := http.NewRequest(method, cfgurl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	signing.Authorize(req, h.Token, h.HMACKey, h.HMACSecret, body)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respbody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("agent returned " + resp.Status + ": " + strings.TrimSpace(string(respbody)))
+	}
+
+	return respbody, nil
+}
+
+// SyncBundles makes the checks on host i of c match its bundles, and
+// records what was different.  Definitions are written as managed, so a
+// later sync can remove them again.  A bundle check whose Label is already
+// defined by hand on the agent is reported as a conflict and not written.
+func SyncBundles(client *http.Client, c *Config, i int) DriftReport {
+	now := time.Now()
+	report := DriftReport{
+		Host:      c.Hosts[i].HostName,
+		Time:      now.Local().Format("Jan 02 2006 03:04:05"),
+		EpochTime: now.Unix(),
+		Bundles:   HostBundles(c, i),
+	}
+	fields := logger.Fields{"agent": report.Host}
+
+	defer func() {
+		driftLock.Lock()
+		drift[report.Host] = report
+		driftLock.Unlock()
+	}()
+
+	defs, err := LoadBundles(c.BundlePath, report.Bundles)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		logger.Error("Not Syncing Bundles To " + report.Host + ": " + err.Error(), fields)
+		return report
+	}
+
+	body, err := agentConfigRequest(client, c, i, "GET", "", nil)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		logger.Error("Couldn't List Checks On " + report.Host + ": " + err.Error(), fields)
+		return report
+	}
+
+	var entries []agentConfigEntry
+	err = json.Unmarshal(body, &entries)
+	if err != nil {
+		report.Errors = append(report.Errors, "bad /config listing: " + err.Error())
+		return report
+	}
+
+	actual := make(map[string]agentConfigEntry)
+	for _, e := range entries {
+		actual[e.Label] = e
+	}
+
+	labels := make([]string, 0, len(defs))
+	for label := range defs {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		sum := sha256.Sum256(defs[label])
+		e, ok := actual[label]
+		if ok && !e.Managed {
+			report.Conflicts = append(report.Conflicts, label)
+			continue
+		}
+		if ok && e.Checksum == hex.EncodeToString(sum[:]) {
+			continue
+		}
+
+		if ok {
+			report.Changed = append(report.Changed, label)
+		} else {
+			report.Missing = append(report.Missing, label)
+		}
+
+		_, err := agentConfigRequest(client, c, i, "PUT", "?managed=true", defs[label])
+		if err != nil {
+			report.Errors = append(report.Errors, label + ": " + err.Error())
+		}
+	}
+
+	for _, e := range entries {
+		if _, ok := defs[e.Label]; ok || !e.Managed {
+			continue
+		}
+
+		report.Extra = append(report.Extra, e.Label)
+		_, err := agentConfigRequest(client, c, i, "DELETE", "?label=" + url.QueryEscape(e.Label), nil)
+		if err != nil {
+			report.Errors = append(report.Errors, e.Label + ": " + err.Error())
+		}
+	}
+	sort.Strings(report.Extra)
+
+	if len(report.Missing) + len(report.Changed) + len(report.Extra) > 0 {
+		logger.Warn("Check Drift On " + report.Host + ": missing [" + strings.Join(report.Missing, ", ") + "] changed [" + strings.Join(report.Changed, ", ") + "] extra [" + strings.Join(report.Extra, ", ") + "]", fields)
+	}
+	if len(report.Conflicts) > 0 {
+		logger.Warn("Bundle Checks On " + report.Host + " Clash With Checks Defined By Hand, Not Syncing Them: [" + strings.Join(report.Conflicts, ", ") + "]", fields)
+	}
+	for _, e := range report.Errors {
+		logger.Error("Syncing Bundles To " + report.Host + ": " + e, fields)
+	}
+
+	return report
+}
+
+// handleDrift shows the last bundle sync of every host, or of ?host=X.
+func handleDrift(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Query().Get("host")
+	reports := []DriftReport{}
+
+	driftLock.Lock()
+	for h, report := range drift {
+		if host == "" || h == host {
+			reports = append(reports, report)
+		}
+	}
+	driftLock.Unlock()
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Host < reports[j].Host })
+
+	jsn, _ := json.Marshal(reports)
+	fmt.Fprintf(w, "%s", jsn)
+}
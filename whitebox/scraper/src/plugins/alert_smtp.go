@@ -1,12 +1,16 @@
 package main
 
 import (
+	"os"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"strconv"
 	"bytes"
         "gopkg.in/yaml.v2"
 	"io/ioutil"
+	"path/filepath"
 	"net/smtp"
 	"net"
 	"time"
@@ -24,11 +28,28 @@ type Config struct {
 type Check struct {
         ConfigLabel string `json:"ConfigLabel"`
         Host        string `json:"Host"`
+        Param       string `json:"Param"`
         TimeStamp   string `json:"TimeStamp"`
         EpochTime   int64  `json:"Epochtime"`
         Command     string `json:"Command"`
         Output      string `json:"Output"`
         Retval      int    `json:"Retval"`
+        State       string `json:"State"`
+        Metrics     []Metric `json:"Metrics"`
+        Silenced    bool   `json:"Silenced"`
+        SilenceReason string `json:"SilenceReason"`
+        Tags        map[string]string `json:"Tags"`
+        Skipped     bool   `json:"Skipped"`
+        SkipReason  string `json:"SkipReason"`
+        Flapping    bool   `json:"Flapping"`
+        PercentStateChange float64 `json:"PercentStateChange"`
+}
+
+type Metric struct {
+        Name   string            `json:"Name"`
+        Value  float64           `json:"Value"`
+        Unit   string            `json:"Unit"`
+        Labels map[string]string `json:"Labels"`
 }
 
 var config Config
@@ -68,31 +89,143 @@ func SendSMTPMessage(mailserver string, from string, to []string, subject string
 	return nil
 }
 
-func Handle(check string, failed bool) (string, error) {
+// parseChecks reads what the scraper hands plugins: the array of results
+// scraped from or pushed by an agent, or the single result it makes up when
+// a scrape fails.
+func parseChecks(data string) ([]Check, error) {
+	var chks []Check
+
+	trimmed := strings.TrimSpace(data)
+	if strings.HasPrefix(trimmed, "[") {
+		err := json.Unmarshal([]byte(trimmed), &chks)
+		return chks, err
+	}
 
 	var chk Check
-	b, err := ioutil.ReadFile("/etc/heimdall/plugins.d/alert_smtp.yml")
+	err := json.Unmarshal([]byte(trimmed), &chk)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	yml := string(b)
-	err = yaml.Unmarshal([]byte(yml), &config)
+	return append(chks, chk), nil
+}
 
-	if err != nil {
-		return "", err
+// lastStates is the last state seen for each check, keyed by host, label
+// and param, so only changes are alerted on.  Plugins stay loaded for the
+// life of the scraper, so it lasts between batches.
+var lastStates = make(map[string]string)
+var statesLock sync.Mutex
+
+// changed records chk's state and says whether it's worth an alert: a check
+// going into a new non-OK state, or recovering to OK.  A failed scrape
+// isn't a configured check, it's alerted on every time.
+func changed(chk Check) bool {
+	if chk.ConfigLabel == "" {
+		return chk.State != "OK"
 	}
 
-	_ = json.Unmarshal([]byte(check), &chk)
+	statesLock.Lock()
+	defer statesLock.Unlock()
+
+	key := chk.Host + "\x00" + chk.ConfigLabel + "\x00" + chk.Param
+	prev, seen := lastStates[key]
+	lastStates[key] = chk.State
 
+	if chk.State == "OK" {
+		return seen && prev != "OK"
+	}
+
+	return prev != chk.State
+}
+
+// checkBody formats one result for an alert.
+func checkBody(chk Check) string {
 	body := "Host: " + chk.Host + "\n"
+	if chk.Param != "" {
+		body += "Param: " + chk.Param + "\n"
+	}
+	if len(chk.Tags) > 0 {
+		var tags []string
+		for k, v := range chk.Tags {
+			tags = append(tags, k + "=" + v)
+		}
+		sort.Strings(tags)
+		body += "Tags: " + strings.Join(tags, " ") + "\n"
+	}
 	body += "TimeStamp: " + chk.TimeStamp + "\n"
 	body += "EpochTime: " + strconv.FormatInt(chk.EpochTime, 10) + "\n"
 	body += "Command: " + chk.Command + "\n"
 	body += "Output: " + chk.Output + "\n"
 	body += "Retval: " + strconv.Itoa(chk.Retval) + "\n"
+	body += "State: " + chk.State + "\n"
+	if chk.Flapping {
+		body += "Flapping: " + strconv.FormatFloat(chk.PercentStateChange, 'f', 1, 64) + "% state change\n"
+	}
+
+	for _, m := range chk.Metrics {
+		body += "Metric: " + m.Name + " = " + strconv.FormatFloat(m.Value, 'f', -1, 64) + " " + m.Unit
+		for k, v := range m.Labels {
+			body += " " + k + "=" + v
+		}
+		body += "\n"
+	}
+
+	return body
+}
+
+func Handle(check string, failed bool) (string, error) {
+
+	configdir := os.Getenv("HEIMDALL_PLUGIN_CONFIG_DIR")
+	if configdir == "" {
+		configdir = "/etc/heimdall/plugins.d/"
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(configdir, "alert_smtp.yml"))
+	if err != nil {
+		return "", err
+	}
+
+	yml := string(b)
+	err = yaml.Unmarshal([]byte(yml), &config)
+
+	if err != nil {
+		return "", err
+	}
+
+	chks, err := parseChecks(check)
+	if err != nil {
+		return "", err
+	}
+
+	var bodies []string
+	suppressed := 0
+	for _, chk := range chks {
+		// The agent flags checks in a maintenance window or silence, don't alert
+		if chk.Silenced {
+			suppressed++
+			continue
+		}
+
+		// A check it depends on failed, that one gets the alert
+		if chk.Skipped {
+			suppressed++
+			continue
+		}
+
+		// Healthy or unchanged results aren't news
+		if chk.State == "" || !changed(chk) {
+			suppressed++
+			continue
+		}
+
+		bodies = append(bodies, checkBody(chk))
+	}
+
+	if len(bodies) == 0 {
+		return "suppressed " + strconv.Itoa(suppressed) + " silenced, skipped or unchanged results", nil
+	}
 
-	err = SendSMTPMessage(config.SMTPServer, config.FromAddress, config.AlertList, config.Subject, body)
+	err = SendSMTPMessage(config.SMTPServer, config.FromAddress, config.AlertList, config.Subject, strings.Join(bodies, "\n"))
 
 	if err != nil {
 		fmt.Println("failed to send alert email")
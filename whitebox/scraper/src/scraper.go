@@ -1,29 +1,52 @@
 package main
 
 import (
+	"agentclient"
+	"crypto/subtle"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/gorilla/mux"
+	"logger"
+	"service"
+	"signing"
+	"tlsutil"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"time"
 	"path/filepath"
 	"plugin"
 	"errors"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
 	AutoClear bool `yaml:"AutoClear"`
 	DefaultScrapeTime int    `yaml:"DefaultScrapeTime"`
 	PluginPath        string `yaml:"PluginPath"`
+	BundlePath        string `yaml:"BundlePath"`
+	GroupBundles      map[string][]string `yaml:"GroupBundles"`
 	Hosts             []struct {
 		HostName       string   `yaml:"HostName"`
 		ScrapeTime     int      `yaml:"ScrapeTime"`
 		HostPaths      []string `yaml:"HostPaths"`
 		Plugins        []string `yaml:"Plugins"`
 		FailurePlugins []string `yaml:"FailurePlugins"`
+		Push           bool     `yaml:"Push"`
+		PushToken      string   `yaml:"PushToken"`
+		PushSecret     string   `yaml:"PushSecret"`
+		TLS            tlsutil.HostTLS `yaml:"TLS"`
+		Token          string   `yaml:"Token"`
+		HMACKey        string   `yaml:"HMACKey"`
+		HMACSecret     string   `yaml:"HMACSecret"`
+		Consumer       string   `yaml:"Consumer"`
+		PageSize       int      `yaml:"PageSize"`
+		Groups         []string `yaml:"Groups"`
+		Bundles        []string `yaml:"Bundles"`
 	} `yaml:"Hosts"`
 
 	DefaultPlugins []string `yaml:"DefaultPlugins"`
@@ -33,11 +56,29 @@ type Config struct {
 type Check struct {
 	ConfigLabel string `json:"ConfigLabel"`
 	Host        string `json:"Host"`
+	Param       string `json:"Param"`
 	TimeStamp   string `json:"TimeStamp"`
 	EpochTime   int64  `json:"Epochtime"`
 	Command     string `json:"Command"`
 	Output      string `json:"Output"`
 	Retval      int    `json:"Retval"`
+	State       string `json:"State"`
+	Metrics     []Metric `json:"Metrics"`
+	Seq         uint64 `json:"Seq"`
+	Silenced    bool   `json:"Silenced"`
+	SilenceReason string `json:"SilenceReason"`
+	Tags        map[string]string `json:"Tags"`
+	Skipped     bool   `json:"Skipped"`
+	SkipReason  string `json:"SkipReason"`
+	Flapping    bool   `json:"Flapping"`
+	PercentStateChange float64 `json:"PercentStateChange"`
+}
+
+type Metric struct {
+	Name   string            `json:"Name"`
+	Value  float64           `json:"Value"`
+	Unit   string            `json:"Unit"`
+	Labels map[string]string `json:"Labels"`
 }
 
 type PluginList struct {
@@ -46,23 +87,49 @@ type PluginList struct {
         Function func(string, bool) (string, error)
 }
 
+var Version = "0.1"
+
+var opts service.Options
+var configDir string
+var pluginConfigDir string
+
 var configs []Config
 var checks []Check
 var plugins []PluginList
 
 
+// ParseFlags fills in the settings above.  Every flag can also be set from
+// the environment, the flag wins if both are given.
+func ParseFlags() {
+	showversion := false
+
+	opts.Flags(":9051", "./heimdall_scraper.log")
+	flag.StringVar(&configDir, "config-dir", service.EnvOr("HEIMDALL_CONFIG_DIR", "/etc/heimdall/scraper.d/"), "directory holding the scrape configs (HEIMDALL_CONFIG_DIR)")
+	flag.StringVar(&pluginConfigDir, "plugin-config-dir", service.EnvOr("HEIMDALL_PLUGIN_CONFIG_DIR", "/etc/heimdall/plugins.d/"), "directory holding the plugin configs (HEIMDALL_PLUGIN_CONFIG_DIR)")
+	flag.BoolVar(&showversion, "version", false, "print the version and exit")
+	flag.Parse()
+
+	if showversion {
+		fmt.Println("Heimdall Scraper " + Version)
+		os.Exit(0)
+	}
+
+	// Plugins run in our process and look here for their own config files.
+	os.Setenv("HEIMDALL_PLUGIN_CONFIG_DIR", pluginConfigDir)
+}
+
 func MakeSkel() error {
-	err := os.MkdirAll("/etc/heimdall/scraper.d/", 0644)
+	err := os.MkdirAll(configDir, 0644)
 	if err != nil {
 		return err
 	}
 
-	err = os.MkdirAll("/etc/heimdall/plugins.d/", 0644)
+	err = os.MkdirAll(pluginConfigDir, 0644)
 	if err != nil {
 		return err
 	}
 
-	file, err := os.OpenFile("/etc/heimdall/scraper.d/default.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(filepath.Join(configDir, "default.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	file.WriteString("# Automatically Clear Checks List When Processed?\n")
 	file.WriteString("# Set To \"false\" if this scraper is a sub-scraper node.\n")
 	file.WriteString("AutoClear: true\n\n")
@@ -70,6 +137,16 @@ func MakeSkel() error {
 	file.WriteString("DefaultScrapeTime: 600\n\n")
 	file.WriteString("# The Path To The Plugins (.so files)\n")
 	file.WriteString("PluginPath: ./plugins\n\n")
+	file.WriteString("# Directory Of Check Bundles, One Sub Directory Of config.d Style\n")
+	file.WriteString("# Files Per Bundle, Kept In Sync On The Hosts They're Assigned To.\n")
+	file.WriteString("# Once Set, Every Host Is Synced, So Removing A Host's Bundles\n")
+	file.WriteString("# Removes Their Checks From It\n")
+	file.WriteString("# BundlePath: /etc/heimdall/bundles.d\n\n")
+	file.WriteString("# Bundles Every Host In A Group Gets\n")
+	file.WriteString("# GroupBundles:\n")
+	file.WriteString("#   web:\n")
+	file.WriteString("#     - base\n")
+	file.WriteString("#     - nginx\n\n")
 	file.WriteString("# List Of Hosts To Check.\n")
 	file.WriteString("Hosts:\n")
 	file.WriteString("  # The Hostname/IP To Check\n")
@@ -86,6 +163,32 @@ func MakeSkel() error {
 	file.WriteString("    # What To Run If Scraping Fails\n")
 	file.WriteString("    FailurePlugins:\n")
 	file.WriteString("      - Alert_SMTP\n\n")
+	file.WriteString("    # Set To \"true\" If This Host's Agent Pushes Its Results\n")
+	file.WriteString("    # To /ingest Instead Of Being Scraped.  Pushes Must Carry\n")
+	file.WriteString("    # The Agent's Push Token, Or Be Signed With Its Push Secret\n")
+	file.WriteString("    Push: false\n")
+	file.WriteString("    # PushSecret: changeme\n\n")
+	file.WriteString("    # Scrape Over https, With A Client Certificate If The Agent Wants One\n")
+	file.WriteString("    TLS:\n")
+	file.WriteString("      Enabled: false\n")
+	file.WriteString("      CAFile: /etc/heimdall/ca.pem\n")
+	file.WriteString("      CertFile: /etc/heimdall/scraper.pem\n")
+	file.WriteString("      KeyFile: /etc/heimdall/scraper-key.pem\n\n")
+	file.WriteString("    # Credentials For The Agent, If It Has Auth Turned On.\n")
+	file.WriteString("    # Either A Bearer Token, Or An HMACKey Name And HMACSecret\n")
+	file.WriteString("    # Token: changeme\n\n")
+	file.WriteString("    # Read From /checks As This Named Consumer And Acknowledge What\n")
+	file.WriteString("    # Was Processed, Instead Of Clearing Results Other Scrapers Need\n")
+	file.WriteString("    # Consumer: primary\n\n")
+	file.WriteString("    # Fetch At Most This Many Results Per Request, Following\n")
+	file.WriteString("    # The Agent's Pages Until It's Drained\n")
+	file.WriteString("    # PageSize: 500\n\n")
+	file.WriteString("    # Check Bundles For This Host, Directly Or Through Its Groups.\n")
+	file.WriteString("    # Syncing Needs Credentials With The Agent's admin Scope\n")
+	file.WriteString("    # Groups:\n")
+	file.WriteString("    #   - web\n")
+	file.WriteString("    # Bundles:\n")
+	file.WriteString("    #   - base\n\n")
 	file.WriteString("# The Plugins To Run If Not Specified In The Host Block\n")
 	file.WriteString("DefaultPlugins:\n")
 	file.WriteString("  - Splunk\n")
@@ -99,17 +202,17 @@ func MakeSkel() error {
 
 	file.Close()
 
-	sfile, err := os.OpenFile("/etc/heimdall/plugins.d/splunk.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	sfile, err := os.OpenFile(filepath.Join(pluginConfigDir, "splunk.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	sfile.WriteString("# Host:Port Of Splunk Server To Send To\n")
 	sfile.WriteString("Host: splunkserver:5021\n")
 	sfile.Close()
 
-	rfile, err := os.OpenFile("/etc/heimdall/plugins.d/rules.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	rfile, err := os.OpenFile(filepath.Join(pluginConfigDir, "rules.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	rfile.WriteString("# Host:Port Of Rules Server To Send To\n")
 	rfile.WriteString("Host: 127.0.0.1:8225\n")
 	rfile.Close()
 
-	ffile, err := os.OpenFile("/etc/heimdall/plugins.d/alert_smtp.yml", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	ffile, err := os.OpenFile(filepath.Join(pluginConfigDir, "alert_smtp.yml"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	ffile.WriteString("# Who To Alert In The Event Of A Failed Scrape\n")
 	ffile.WriteString("AlertList:\n")
 	ffile.WriteString("  - someone@yourdomain.com\n\n")
@@ -124,47 +227,42 @@ func MakeSkel() error {
 	return nil
 }
 
-func Log(message string) {
-	file, err := os.OpenFile("./heimdall_scraper.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		fmt.Println("Failed To Open Log File: " + err.Error())
-	}
-	defer file.Close()
-
-	current_time := time.Now().Local()
-	t := current_time.Format("Jan 02 2006 03:04:05")
-	_, err = file.WriteString(t + " - Heimdall Scraper: " + message + "\n")
+// Log writes an informational message.  Warnings and errors go through the
+// logger package directly so they carry the right level.
+func Log(message string, fields ...logger.Fields) {
+	logger.Info(message, fields...)
 }
 
 func GetConfigs() {
 
-	_, err := os.Stat("/etc/heimdall/scraper.d/")
+	_, err := os.Stat(configDir)
 	if os.IsNotExist(err) {
 		err := MakeSkel()
 		if err != nil {
-			fmt.Println("Error Setting Up /etc/heimdall/scraper.d/ and default settings file: " + err.Error())
-			Log("Error Setting Up /etc/heimdall/scraper.d/ and default settings file: " + err.Error())
+			fmt.Println("Error Setting Up " + configDir + " and default settings file: " + err.Error())
+			logger.Error("Error Setting Up " + configDir + " and default settings file: " + err.Error())
 			return
 		}
 	}
 
-	files, err := ioutil.ReadDir("/etc/heimdall/scraper.d/")
+	files, err := ioutil.ReadDir(configDir)
 	if err != nil {
-		fmt.Println("Error Reading /etc/heimdall/scraper.d/: " + err.Error())
-		Log("Error Reading /etc/heimdall/scraper.d/: " + err.Error())
+		fmt.Println("Error Reading " + configDir + ": " + err.Error())
+		logger.Error("Error Reading " + configDir + ": " + err.Error())
 		return
 	}
 
 	if len(files) < 1 {
-		fmt.Println("/etc/heimdall/scraper.d/ exists, but is empty. No Configs Loaded")
-		Log("/etc/heimdall/scraper.d/ exists, but is empty. No Configs Loaded")
+		fmt.Println(configDir + " exists, but is empty. No Configs Loaded")
+		logger.Warn(configDir + " exists, but is empty. No Configs Loaded")
 	}
 
 	for _, f := range files {
-		b, err := ioutil.ReadFile("/etc/heimdall/scraper.d/" + f.Name())
+		b, err := ioutil.ReadFile(filepath.Join(configDir, f.Name()))
 		if err != nil {
-			fmt.Println("Error Opening File: /etc/heimdall/scraper.d/" + f.Name() + ": " + err.Error())
-			Log("Error Opening File: /etc/heimdall/scraper.d/" + f.Name() + ": " + err.Error())
+			fmt.Println("Error Opening File: " + filepath.Join(configDir, f.Name()) + ": " + err.Error())
+			logger.Error("Error Opening File: " + filepath.Join(configDir, f.Name()) + ": " + err.Error())
+			continue
 		}
 
 		yml := string(b)
@@ -173,8 +271,9 @@ func GetConfigs() {
 		err = yaml.Unmarshal([]byte(yml), &c)
 
 		if err != nil {
-			fmt.Println("Couldn't Parse YAML File /etc/heimdall/scraper.d/" + f.Name() + ": " + err.Error())
-			Log("Couldn't Parse YAML File /etc/heimdall/scraper.d/" + f.Name() + ": " + err.Error())
+			fmt.Println("Couldn't Parse YAML File " + filepath.Join(configDir, f.Name()) + ": " + err.Error())
+			logger.Error("Couldn't Parse YAML File " + filepath.Join(configDir, f.Name()) + ": " + err.Error())
+			continue
 		}
 
 		configs = append(configs, c)
@@ -192,12 +291,12 @@ func handlePing(w http.ResponseWriter, r *http.Request) {
 
 func handleChecks(w http.ResponseWriter, r *http.Request) {
 	jsn, _ := json.Marshal(checks)
-	fmt.Fprintf(w, string(jsn))
+	w.Write(jsn)
 }
 
 func handleCheckAndClear(w http.ResponseWriter, r *http.Request) {
 	jsn, _ := json.Marshal(checks)
-	fmt.Fprintf(w, string(jsn))
+	w.Write(jsn)
 	checks = nil
 }
 
@@ -209,28 +308,30 @@ func handleStatusOf(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	param := r.URL.Query().Get("param")
+
 	for _, chk := range checks {
-		if chk.ConfigLabel == check {
+		if chk.ConfigLabel == check && (param == "" || chk.Param == param) {
 			retvals = append(retvals, chk)
 		}
 	}
 
 	jsn, _ := json.Marshal(retvals)
-	fmt.Fprintf(w, string(jsn))
+	w.Write(jsn)
 }
 
 func LoadPlugins(plgpath string) error {
 	_, er := os.Stat(plgpath)
 	if os.IsNotExist(er) {
-		Log("Plugin Path Doesn't Exist (" + plgpath + ")")
-		Log("No Plugins Loaded")
+		logger.Warn("Plugin Path Doesn't Exist (" + plgpath + ")")
+		logger.Warn("No Plugins Loaded")
 		fmt.Println("No Plugins Loaded")
 		return er
 	}
 
         all_plugins, err := filepath.Glob(plgpath + "/*.so")
         if err != nil {
-		Log("Error Getting Files From: " + plgpath + ": " + err.Error())
+		logger.Error("Error Getting Files From: " + plgpath + ": " + err.Error())
 		return err
         }
 
@@ -242,43 +343,43 @@ func LoadPlugins(plgpath string) error {
 
                 symbol, err := p.Lookup("Handle")
 		if err != nil {
-			Log("failed to look up Function: " + err.Error())
-			Log("Plugin Not Loaded: " + filename)
+			logger.Error("failed to look up Function: " + err.Error())
+			logger.Warn("Plugin Not Loaded: " + filename)
 			continue
 		}
 
                 nsymbol, err := p.Lookup("PluginName")
 		if err != nil {
-			Log("failed to look up Plugin Name: " + err.Error())
-			Log("Plugin Not Loaded: " + filename)
+			logger.Error("failed to look up Plugin Name: " + err.Error())
+			logger.Warn("Plugin Not Loaded: " + filename)
 			continue
 		}
 
                 vsymbol, err := p.Lookup("PluginVersion")
                 if err != nil {
-			Log("failed to look up Plugin Version: " + err.Error())
-			Log("Plugin Not Loaded: " + filename)
+			logger.Error("failed to look up Plugin Version: " + err.Error())
+			logger.Warn("Plugin Not Loaded: " + filename)
 			continue
                 }
 
                 plgname, ok := nsymbol.(*string)
 		if !ok {
-			Log("failed to load name symbol from: " + filename)
-			Log("Plugin Not Loaded")
+			logger.Error("failed to load name symbol from: " + filename)
+			logger.Warn("Plugin Not Loaded")
 			continue
 		}
 
                 plgversion, ok := vsymbol.(*string)
 		if !ok {
-			Log("failed to load name symbol from: " + filename)
-			Log("Plugin Not Loaded")
+			logger.Error("failed to load name symbol from: " + filename)
+			logger.Warn("Plugin Not Loaded")
 			continue
 		}
 
                 plgfunc, ok := symbol.(func(string, bool) (string, error))
                 if !ok {
-			Log("failed to load name symbol from: " + filename)
-			Log("Plugin Not Loaded")
+			logger.Error("failed to load name symbol from: " + filename)
+			logger.Warn("Plugin Not Loaded")
 			continue
                 }
 
@@ -290,7 +391,7 @@ func LoadPlugins(plgpath string) error {
 		flag := false
 		for _, p := range plugins {
 			if p.Name == tmpplg.Name {
-				Log("Plugin Already Loaded: " + p.Name)
+				logger.Warn("Plugin Already Loaded: " + p.Name)
 				flag = true
 			}
 		}
@@ -301,7 +402,7 @@ func LoadPlugins(plgpath string) error {
         }
 
 	if len(plugins) < 1 {
-		Log("No Plugins Loaded: Do .so files exist in: " + plgpath + "?")
+		logger.Warn("No Plugins Loaded: Do .so files exist in: " + plgpath + "?")
 		fmt.Println("No Plugins Loaded: Do .so files exist in: " + plgpath + "?")
 		return errors.New("No Plugins Loaded")
 	} else {
@@ -309,6 +410,36 @@ func LoadPlugins(plgpath string) error {
 	}
 }
 
+// HostCredentials are what to call host i of c with.
+func HostCredentials(c *Config, i int) agentclient.Credentials {
+	return agentclient.Credentials{Token: c.Hosts[i].Token, HMACKey: c.Hosts[i].HMACKey, HMACSecret: c.Hosts[i].HMACSecret}
+}
+
+// RunPlugins hands data to every loaded plugin named in plgnames.  It
+// returns false if none of the names matched a loaded plugin.
+func RunPlugins(plgnames []string, data string) bool {
+	flag := false
+	for _, plgname := range plgnames {
+		for _, p := range plugins {
+			if p.Name == plgname {
+				flag = true
+				retval, err := p.Function(data, false)
+				if err != nil {
+					logger.Error("Failed to execute (" + p.Name + "): " + err.Error(), logger.Fields{"plugin": p.Name})
+				} else {
+					Log("Successfully ran (" + p.Name + "): " + retval, logger.Fields{"plugin": p.Name})
+				}
+			}
+		}
+	}
+
+	if ! flag {
+		logger.Warn("No Plugin Names Matching.  Check Config")
+	}
+
+	return flag
+}
+
 func Do_Scrapes(c *Config) {
 	var check Check
 
@@ -316,127 +447,113 @@ func Do_Scrapes(c *Config) {
 		c.DefaultScrapeTime = 300
 	}
 
+	clients := make([]*http.Client, len(c.Hosts))
+	pulled := 0
+	for i, h := range c.Hosts {
+		if h.Push {
+			continue
+		}
+
+		client, err := h.TLS.Client()
+		if err != nil {
+			logger.Error("Bad TLS Settings For " + h.HostName + ", Not Scraping It: " + err.Error())
+			continue
+		}
+
+		clients[i] = client
+		pulled++
+	}
+
+	if pulled == 0 {
+		return
+	}
+
 	for {
 		for i := 0; i < len(c.Hosts); i++ {
+			// Push hosts, and hosts with bad TLS settings, have no client
+			if clients[i] == nil {
+				continue
+			}
+
 			if c.Hosts[i].ScrapeTime == 0 {
 				c.Hosts[i].ScrapeTime = c.DefaultScrapeTime
 			}
 
 			if len(c.Hosts[i].HostPaths) == 0 {
-				c.Hosts[i].HostPaths = append(c.Hosts[i].HostPaths, "/checkandclear")
+				if c.Hosts[i].Consumer != "" {
+					c.Hosts[i].HostPaths = append(c.Hosts[i].HostPaths, "/checks")
+				} else {
+					c.Hosts[i].HostPaths = append(c.Hosts[i].HostPaths, "/checkandclear")
+				}
 			}
 
 			time.Sleep(time.Duration(c.Hosts[i].ScrapeTime) * time.Second)
 
+			// Sync even with no bundles, to remove checks an earlier sync left
+			if c.BundlePath != "" {
+				SyncBundles(clients[i], c, i)
+			}
+
 			for _, hp := range c.Hosts[i].HostPaths {
-				resp, err := http.Get("http://" + c.Hosts[i].HostName + hp)
-				if err != nil {
-					now := time.Now()
-					current_time := time.Now().Local()
-					epoch := now.Unix()
-					t := current_time.Format("Jan 02 2006 03:04:05")
-
-					check.Host = c.Hosts[i].HostName
-					check.TimeStamp = t
-					check.EpochTime = epoch
-					check.Command = "scrape: " + c.Hosts[i].HostName + hp
-					check.Output = "failed to scrape: " + err.Error()
-					check.Retval = 1
-					
-					bytes, _ := json.Marshal(check)
-
-					psize := len(c.Hosts[i].FailurePlugins)
-
-					if psize < 1 {
-						flag := false
-						for _, failplg := range c.DefaultFailPlugins {
-							for _, p := range plugins {
-								if p.Name == failplg {
-									flag = true
-									retval, err := p.Function(string(bytes), false)
-									if err != nil {
-										Log("Failed to execute (" + p.Name + "): " + err.Error())
-									} else {
-										Log("Successfully ran (" + p.Name + "): " + retval)
-									}
-								}
-							}
-						}
+				scrapeurl := c.Hosts[i].TLS.Scheme() + c.Hosts[i].HostName + hp
+				if c.Hosts[i].Consumer != "" {
+					scrapeurl = agentclient.WithQuery(scrapeurl, "consumer", c.Hosts[i].Consumer)
+				}
+				if c.Hosts[i].PageSize > 0 {
+					scrapeurl = agentclient.WithQuery(scrapeurl, "limit", strconv.Itoa(c.Hosts[i].PageSize))
+				}
 
-						if ! flag  {
-							Log("No Plugin Names Matching.  Check Config")
-						}
-					} else {
-						flag := false
-						for _, failplg := range c.Hosts[i].FailurePlugins {
-							for _, p := range plugins {
-								if p.Name == failplg {
-									flag = true
-									retval, err := p.Function(string(bytes), false)
-									if err != nil {
-										Log("Failed to execute ("+ p.Name + "): " + err.Error())
-									} else {
-										Log("Successfully ran (" + p.Name + "): " + retval)
-									}
-								}
-							}
-						}
+				// Follow the agent's pages until it has nothing more
+				pageurl := scrapeurl
+				for pageurl != "" {
+					bytes, next, err := agentclient.ScrapePage(clients[i], pageurl, HostCredentials(c, i))
+
+					pageurl = ""
+					if err == nil {
+						pageurl = agentclient.NextPage(scrapeurl, hp, next)
+					}
 
-						if ! flag {
-							Log("No Plugin Names Matching.  Check Config")
+					if err != nil {
+						now := time.Now()
+						current_time := time.Now().Local()
+						epoch := now.Unix()
+						t := current_time.Format("Jan 02 2006 03:04:05")
+
+						check.Host = c.Hosts[i].HostName
+						check.TimeStamp = t
+						check.EpochTime = epoch
+						check.Command = "scrape: " + c.Hosts[i].HostName + hp
+						check.Output = "failed to scrape: " + err.Error()
+						check.Retval = 1
+						check.State = "CRITICAL"
+
+						bytes, _ := json.Marshal(check)
+
+						if len(c.Hosts[i].FailurePlugins) < 1 {
+							RunPlugins(c.DefaultFailPlugins, string(bytes))
+						} else {
+							RunPlugins(c.Hosts[i].FailurePlugins, string(bytes))
 						}
+						continue
 					}
-				} else {
-					defer resp.Body.Close()
-					var chk []Check
-					bytes, _ := ioutil.ReadAll(resp.Body)
-					json.Unmarshal(bytes, &chk)
-					psize := len(c.Hosts[i].Plugins)
 
 					// Everything worked, but agent had no data
-					if string(bytes) == "null" {
+					if string(bytes) == "null" || string(bytes) == "[]" {
 						continue
 					}
 
-					if psize < 1 {
-						// Do Default Plugins
-						flag := false
-						for _, hstplg := range c.DefaultPlugins {
-							for _, p := range plugins {
-								if p.Name == hstplg {
-									flag = true
-									retval, err := p.Function(string(bytes), false)
-									if err != nil {
-										Log("Failed to execute ("+ p.Name + "): " + err.Error())
-									} else {
-										Log("Successfully ran (" + p.Name + "): " + retval)
-									}
-								}
-							}
-						}
-
-						if ! flag {
-							Log("No Plugin Names Matching.  Check Config")	
-						}
-
+					ran := false
+					if len(c.Hosts[i].Plugins) < 1 {
+						ran = RunPlugins(c.DefaultPlugins, string(bytes))
 					} else {
-						flag := false
-						for _, hstplg := range c.Hosts[i].Plugins {
-							for _, p := range plugins {
-								if p.Name == hstplg {
-									flag = true
-									retval, err := p.Function(string(bytes), false)
-									if err != nil {
-										Log("Failed to execute ("+ p.Name + "): " + err.Error())
-									} else {
-										Log("Successfully ran (" + p.Name + "): " + retval)
-									}
-								}
-							}
-						}
+						ran = RunPlugins(c.Hosts[i].Plugins, string(bytes))
+					}
 
-						if ! flag {
-							Log("No Plugin Names Matching.  Check Config")	
+					ackurl := agentclient.AckURL(c.Hosts[i].TLS.Scheme() + c.Hosts[i].HostName, c.Hosts[i].Consumer, bytes)
+					if ran && c.Hosts[i].Consumer != "" && ackurl != "" {
+						err := agentclient.Ack(clients[i], ackurl, HostCredentials(c, i))
+						if err != nil {
+							logger.Warn("Failed To Acknowledge Results From " + c.Hosts[i].HostName + ": " + err.Error(), logger.Fields{"agent": c.Hosts[i].HostName})
 						}
 					}
 				}
@@ -445,7 +562,153 @@ func Do_Scrapes(c *Config) {
 	}
 }
 
+// The largest batch of pushed results /ingest reads.
+const maxIngestSize = 64 * 1024 * 1024
+
+// ingestVerifier checks pushes signed with a host's PushSecret.
+var ingestVerifier = &signing.Verifier{MaxBody: maxIngestSize}
+
+// hostOnly is name without any port, as agents report their host.
+func hostOnly(name string) string {
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		return host
+	}
+
+	return name
+}
+
+// FindPushHost returns the config and index of the host that pushes as
+// hostname, matching it against HostName with or without the port.  Hosts
+// that aren't marked Push are never matched, they're scraped instead.
+func FindPushHost(hostname string) (*Config, int, bool) {
+	for ci := range configs {
+		for i, h := range configs[ci].Hosts {
+			if h.Push && (h.HostName == hostname || hostOnly(h.HostName) == hostname) {
+				return &configs[ci], i, true
+			}
+		}
+	}
+
+	return nil, 0, false
+}
+
+// authenticatePush returns the host a push came from: the Push host with
+// the bearer token it carries, or the one it was signed as.
+func authenticatePush(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token := strings.TrimPrefix(header, "Bearer ")
+		for _, c := range configs {
+			for _, h := range c.Hosts {
+				if h.Push && h.PushToken != "" && subtle.ConstantTimeCompare([]byte(h.PushToken), []byte(token)) == 1 {
+					return hostOnly(h.HostName), nil
+				}
+			}
+		}
+		return "", errors.New("unknown token")
+	}
+
+	return ingestVerifier.Verify(r, func(keyname string) (string, bool) {
+		c, i, ok := FindPushHost(keyname)
+		if !ok || c.Hosts[i].PushSecret == "" {
+			return "", false
+		}
+		return c.Hosts[i].PushSecret, true
+	})
+}
+
+// handleIngest accepts results POSTed by agents in push mode and feeds them
+// to the same plugins as scraped results.  Only hosts marked Push are
+// accepted, each push has to be authenticated as that host, and it may
+// only carry that host's results.
+func handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "ingest requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxIngestSize)
+
+	host, err := authenticatePush(r)
+	if err == signing.ErrTooLarge {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		logger.Warn("Rejected Unauthenticated Push From " + r.RemoteAddr + ": " + err.Error())
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	c, i, ok := FindPushHost(host)
+	if !ok {
+		logger.Warn("Rejected Pushed Checks From Unknown Host: " + host)
+		http.Error(w, "unknown host: " + host, http.StatusForbidden)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read body: " + err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Results are passed on as the agent sent them, so plugins see every
+	// field just as they do for scraped results.
+	var chks []json.RawMessage
+	err = json.Unmarshal(body, &chks)
+	if err != nil {
+		http.Error(w, "failed to parse checks: " + err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, raw := range chks {
+		var chk Check
+		err = json.Unmarshal(raw, &chk)
+		if err != nil {
+			http.Error(w, "failed to parse check: " + err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if chk.Host != host {
+			logger.Warn("Rejected Pushed Checks For " + chk.Host + " From " + host)
+			http.Error(w, "results for " + chk.Host + " pushed as " + host, http.StatusForbidden)
+			return
+		}
+	}
+
+	plgnames := c.Hosts[i].Plugins
+	if len(plgnames) < 1 {
+		plgnames = c.DefaultPlugins
+	}
+	RunPlugins(plgnames, string(body))
+
+	fmt.Fprintf(w, "accepted %d checks", len(chks))
+}
+
 func main() {
+	// "scraper validate [flags]" checks the configs and plugins and exits.
+	validate := len(os.Args) > 1 && os.Args[1] == "validate"
+	if validate {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
+	ParseFlags()
+
+	if validate {
+		os.Exit(Validate())
+	}
+
+	err := opts.InitLogging("scraper")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed To Set Up Logging, Using stderr: " + err.Error())
+	}
+
+	err = opts.WritePidFile()
+	if err != nil {
+		fmt.Println("Failed To Write PID File: " + err.Error())
+		logger.Error("Failed To Write PID File: " + err.Error())
+	}
+
 	GetConfigs()
 	for _, co := range configs {
 		LoadPlugins(co.PluginPath)
@@ -462,8 +725,10 @@ func main() {
 	router.HandleFunc("/checks", handleChecks)
 	router.HandleFunc("/checkandclear", handleCheckAndClear)
 	router.HandleFunc("/statusof", handleStatusOf)
+	router.HandleFunc("/ingest", handleIngest)
+	router.HandleFunc("/drift", handleDrift)
 
-	err := http.ListenAndServe(":9051", router)
+	err = opts.Serve(router)
 	if err != nil {
 		fmt.Println("ListenAndServe: ", err)
 	}
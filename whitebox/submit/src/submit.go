@@ -0,0 +1,238 @@
+// heimdall-submit sends a passive check result to the local agent's /submit
+// endpoint, for batch jobs and cron scripts.  Either give the result with
+// -state and -output, or put a command after the flags and its exit code and
+// output become the result:
+//
+//	heimdall-submit -label backup -state OK -output "nightly backup done"
+//	heimdall-submit -label backup -- /usr/local/bin/backup.sh
+//
+// When wrapping a command, heimdall-submit exits with the command's exit
+// code, so cron still sees failures.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"signing"
+	"strconv"
+	"strings"
+	"time"
+	"tlsutil"
+)
+
+var Version = "0.1"
+
+// Submission matches what the agent's /submit takes.
+type Submission struct {
+	Label   string
+	Param   string   `json:",omitempty"`
+	State   string
+	Output  string
+	Value   float64  `json:",omitempty"`
+	Metrics []Metric `json:",omitempty"`
+}
+
+type Metric struct {
+	Name  string
+	Value float64
+	Unit  string `json:",omitempty"`
+}
+
+// metricFlags collects repeated -metric name=value[:unit] flags.
+type metricFlags []Metric
+
+func (m *metricFlags) String() string {
+	return fmt.Sprint(*m)
+}
+
+func (m *metricFlags) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return errors.New("want name=value[:unit]")
+	}
+
+	value, unit := parts[1], ""
+	if i := strings.Index(value, ":"); i >= 0 {
+		value, unit = value[:i], value[i+1:]
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+
+	*m = append(*m, Metric{Name: parts[0], Value: v, Unit: unit})
+	return nil
+}
+
+func envOr(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return def
+}
+
+// exitState maps a wrapped command's exit code to a state.  With nagios set
+// the Nagios plugin codes are used, otherwise anything but 0 is CRITICAL.
+func exitState(code int, nagios bool) string {
+	if code == 0 {
+		return "OK"
+	}
+
+	if !nagios {
+		return "CRITICAL"
+	}
+
+	switch code {
+	case 1:
+		return "WARNING"
+	case 2:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Most of a wrapped command's output that's sent, well under the 1MB the
+// agent takes for a whole submission.
+const maxOutput = 64 * 1024
+
+// truncateOutput keeps the end of out, where a failing job's errors usually
+// are, if it's over maxOutput.
+func truncateOutput(out []byte) []byte {
+	if len(out) <= maxOutput {
+		return out
+	}
+
+	return append([]byte("[output truncated]\n"), out[len(out)-maxOutput:]...)
+}
+
+// runWrapped runs args, returning its exit code and combined output.
+func runWrapped(args []string) (int, string) {
+	cmd := exec.Command(args[0], args[1:]...)
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(truncateOutput(out)))
+
+	if err != nil {
+		if exiterr, ok := err.(*exec.ExitError); ok {
+			if code := exiterr.ExitCode(); code >= 0 {
+				return code, output
+			}
+		}
+		if output != "" {
+			output += "\n"
+		}
+		return 127, output + err.Error()
+	}
+
+	return 0, output
+}
+
+func client(cafile string, insecure bool) (*http.Client, error) {
+	tlsconfig, err := tlsutil.ClientConfig(cafile, "", "", "", insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsconfig},
+	}, nil
+}
+
+func submit(agent string, sub Submission, token string, keyname string, secret string, cafile string, insecure bool) error {
+	body, _ := json.Marshal(sub)
+
+	req, err := http.NewRequest("POST", strings.TrimRight(agent, "/") + "/submit", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signing.Authorize(req, token, keyname, secret, body)
+
+	c, err := client(cafile, insecure)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return errors.New("agent returned " + resp.Status + ": " + strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+func main() {
+	var sub Submission
+	var metrics metricFlags
+	showversion := false
+	nagios := false
+
+	agent := flag.String("agent", envOr("HEIMDALL_AGENT_URL", "http://localhost:9050"), "agent to submit to (HEIMDALL_AGENT_URL)")
+	token := flag.String("token", envOr("HEIMDALL_TOKEN", ""), "bearer token for the agent (HEIMDALL_TOKEN)")
+	keyname := flag.String("key", envOr("HEIMDALL_KEY", ""), "HMAC key name for the agent (HEIMDALL_KEY)")
+	secret := flag.String("secret", envOr("HEIMDALL_SECRET", ""), "HMAC secret for the agent (HEIMDALL_SECRET)")
+	cafile := flag.String("ca", envOr("HEIMDALL_CA", ""), "CA to verify an https agent with (HEIMDALL_CA)")
+	insecure := flag.Bool("insecure", false, "don't verify the agent's certificate")
+	flag.StringVar(&sub.Label, "label", "", "passive check label, as configured on the agent")
+	flag.StringVar(&sub.Param, "param", "", "parameter the result is for, if any")
+	flag.StringVar(&sub.State, "state", "", "OK, WARNING, CRITICAL or UNKNOWN")
+	flag.StringVar(&sub.Output, "output", "", "result text")
+	flag.Float64Var(&sub.Value, "value", 0, "value to compare against the check's thresholds")
+	flag.Var(&metrics, "metric", "metric as name=value[:unit], can be repeated")
+	flag.BoolVar(&nagios, "nagios", false, "read a wrapped command's exit code as a Nagios plugin's")
+	flag.BoolVar(&showversion, "version", false, "print the version and exit")
+	flag.Parse()
+
+	if showversion {
+		fmt.Println("Heimdall Submit " + Version)
+		os.Exit(0)
+	}
+
+	if sub.Label == "" {
+		fmt.Fprintln(os.Stderr, "missing -label")
+		os.Exit(2)
+	}
+
+	exitcode := 0
+	if args := flag.Args(); len(args) > 0 {
+		code, output := runWrapped(args)
+		exitcode = code
+		if sub.State == "" {
+			sub.State = exitState(code, nagios)
+		}
+		if sub.Output == "" {
+			sub.Output = output
+		}
+	} else if sub.State == "" {
+		fmt.Fprintln(os.Stderr, "missing -state, or a command to run")
+		os.Exit(2)
+	}
+	sub.State = strings.ToUpper(sub.State)
+	sub.Metrics = metrics
+
+	err := submit(*agent, sub, *token, *keyname, *secret, *cafile, *insecure)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed To Submit " + sub.Label + ": " + err.Error())
+		if exitcode == 0 {
+			exitcode = 1
+		}
+	}
+
+	os.Exit(exitcode)
+}